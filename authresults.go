@@ -0,0 +1,300 @@
+package spf
+
+import "strings"
+
+// An Authentication-Results header as described in RFC 8601. Only the
+// results of the spf method are kept
+type AuthenticationResults struct {
+	AuthServID string // Domain name of the host which evaluated the results
+	Results    []AuthResult
+}
+
+// Result of the spf method in an Authentication-Results header
+type AuthResult struct {
+	Result   Result
+	Reason   string // Optional text explaining the result
+	MailFrom string // smtp.mailfrom, the checked MAIL FROM identity
+	Helo     string // smtp.helo, the checked HELO identity
+}
+
+// Returns the header field including the name "Authentication-Results",
+// folded to lines of at most 78 characters and without trailing CRLF
+//
+//	Authentication-Results: mx.example.org; spf=pass
+//		smtp.mailfrom=info@example.com smtp.helo=mail.example.com
+func (a AuthenticationResults) String() string {
+	return fold("Authentication-Results:", a.tokens())
+}
+
+// Returns the header field body without name and folding
+func (a AuthenticationResults) Value() string {
+	return strings.Join(a.tokens(), " ")
+}
+
+func (a AuthenticationResults) tokens() []string {
+	tokens := []string{authValue(a.AuthServID) + ";"}
+
+	if len(a.Results) == 0 {
+		return append(tokens, "none")
+	}
+
+	for i, result := range a.Results {
+		tokens = append(tokens, "spf="+string(result.Result))
+
+		if result.Reason != "" {
+			tokens = append(tokens, "reason="+authValue(result.Reason))
+		}
+
+		if result.MailFrom != "" {
+			tokens = append(tokens, "smtp.mailfrom="+authProperty(result.MailFrom))
+		}
+
+		if result.Helo != "" {
+			tokens = append(tokens, "smtp.helo="+authProperty(result.Helo))
+		}
+
+		if i < len(a.Results)-1 {
+			tokens[len(tokens)-1] += ";"
+		}
+	}
+
+	return tokens
+}
+
+// Returns value as token (RFC 2045 section 5.1) or, if it isn't one, as quoted-string
+func authValue(value string) string {
+	if isToken(value) {
+		return value
+	}
+
+	return quoteString(value)
+}
+
+// Like authValue, but mail addresses and domain names are kept as they are
+func authProperty(value string) string {
+	at := strings.LastIndexByte(value, '@')
+
+	if at >= 0 && (at == 0 || isDotAtom(value[:at])) && isDotAtom(value[at+1:]) {
+		return value
+	}
+
+	return authValue(value)
+}
+
+func isToken(value string) bool {
+	if value == "" {
+		return false
+	}
+
+	for i := 0; i < len(value); i++ {
+		if value[i] <= ' ' || value[i] >= 0x7f || strings.IndexByte(`()<>@,;:\"/[]?=`, value[i]) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Reads an Authentication-Results header. The field name is optional, results
+// of other methods than spf are skipped
+//
+// Returns ErrInvalidHeader if the header doesn't follow the syntax of RFC 8601
+// or a spf result isn't one of the results of RFC 7208. This includes the
+// policy result of RFC 8601 section 2.7.2, which has no counterpart in Result
+func ParseAuthenticationResults(header string) (AuthenticationResults, error) {
+	var a AuthenticationResults
+
+	if colon := strings.IndexByte(header, ':'); colon >= 0 && strings.EqualFold(strings.TrimSpace(header[:colon]), "Authentication-Results") {
+		header = header[colon+1:]
+	}
+
+	tokens, err := authTokens(header)
+
+	if err != nil {
+		return a, err
+	}
+
+	if len(tokens) == 0 || tokens[0].special {
+		return a, ErrInvalidHeader
+	}
+
+	a.AuthServID = tokens[0].text
+	tokens = tokens[1:]
+
+	// The authserv-id can be followed by a version
+	if len(tokens) > 0 && !tokens[0].special && isDigits(tokens[0].text) {
+		tokens = tokens[1:]
+	}
+
+	if len(tokens) == 2 && tokens[0].is(";") && strings.EqualFold(tokens[1].text, "none") {
+		return a, nil
+	}
+
+	for len(tokens) > 0 {
+		if !tokens[0].is(";") {
+			return a, ErrInvalidHeader
+		}
+
+		end := 1
+
+		for end < len(tokens) && !tokens[end].is(";") {
+			end++
+		}
+
+		result, isSPF, err := parseResInfo(tokens[1:end])
+
+		if err != nil {
+			return a, err
+		}
+
+		if isSPF {
+			a.Results = append(a.Results, result)
+		}
+
+		tokens = tokens[end:]
+	}
+
+	return a, nil
+}
+
+// Reads a resinfo without the leading semicolon. isSPF is false for other methods
+func parseResInfo(tokens []authToken) (result AuthResult, isSPF bool, err error) {
+	// methodspec = method [ "/" method-version ] "=" result
+	if len(tokens) < 3 || tokens[0].special {
+		return result, false, ErrInvalidHeader
+	}
+
+	// The method-version is part of the word of the method
+	method, _, _ := strings.Cut(tokens[0].text, "/")
+	tokens = tokens[1:]
+
+	if !tokens[0].is("=") || tokens[1].special {
+		return result, false, ErrInvalidHeader
+	}
+
+	result.Result = Result(strings.ToLower(tokens[1].text))
+	tokens = tokens[2:]
+
+	// reasonspec and propspecs, both are name "=" value
+	for len(tokens) > 0 {
+		if len(tokens) < 3 || tokens[0].special || !tokens[1].is("=") || tokens[2].special {
+			return result, false, ErrInvalidHeader
+		}
+
+		switch strings.ToLower(tokens[0].text) {
+		case "reason":
+			result.Reason = tokens[2].text
+		case "smtp.mailfrom":
+			result.MailFrom = tokens[2].text
+		case "smtp.helo":
+			result.Helo = tokens[2].text
+		}
+
+		tokens = tokens[3:]
+	}
+
+	isSPF = strings.EqualFold(method, "spf")
+
+	if isSPF && !result.Result.valid() {
+		return result, false, ErrInvalidHeader
+	}
+
+	return result, isSPF, nil
+}
+
+// A word, quoted-string or special character of a header
+type authToken struct {
+	text    string
+	special bool // ; or =
+}
+
+func (t authToken) is(special string) bool {
+	return t.special && t.text == special
+}
+
+// Splits a header into tokens. Whitespace and comments are dropped and
+// quoted-strings are unquoted. Values after "=" can contain "=" themselves,
+// like SRS addresses
+func authTokens(header string) ([]authToken, error) {
+	var tokens []authToken
+
+	for i := 0; i < len(header); {
+		c := header[i]
+		end := " \t\r\n();=\""
+		value := len(tokens) > 0 && tokens[len(tokens)-1].is("=")
+
+		if value {
+			end = " \t\r\n();\""
+		}
+
+		switch {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			i++
+		case c == '(':
+			// Comments can be nested and contain quoted-pairs
+			depth := 0
+
+			for ; i < len(header); i++ {
+				if header[i] == '\\' {
+					i++
+				} else if header[i] == '(' {
+					depth++
+				} else if header[i] == ')' {
+					depth--
+
+					if depth == 0 {
+						break
+					}
+				}
+			}
+
+			if depth != 0 {
+				return nil, ErrInvalidHeader
+			}
+
+			i++
+		case c == '"':
+			var text strings.Builder
+			i++
+
+			for ; i < len(header) && header[i] != '"'; i++ {
+				if header[i] == '\\' && i+1 < len(header) {
+					i++
+				}
+
+				if header[i] != '\r' && header[i] != '\n' {
+					text.WriteByte(header[i])
+				}
+			}
+
+			if i == len(header) {
+				return nil, ErrInvalidHeader
+			}
+
+			i++
+
+			// A quoted local-part of a mail address is followed by the domain
+			for i < len(header) && strings.IndexByte(end, header[i]) < 0 {
+				text.WriteByte(header[i])
+				i++
+			}
+
+			tokens = append(tokens, authToken{text: text.String()})
+		case c == ';' || c == '=' && !value:
+			tokens = append(tokens, authToken{text: string(c), special: true})
+			i++
+		case c == ')':
+			return nil, ErrInvalidHeader
+		default:
+			start := i
+
+			for i < len(header) && strings.IndexByte(end, header[i]) < 0 {
+				i++
+			}
+
+			tokens = append(tokens, authToken{text: header[start:i]})
+		}
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,134 @@
+package spf_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/moverval/go-spf"
+)
+
+func TestAuthenticationResults(t *testing.T) {
+	header := spf.AuthenticationResults{
+		AuthServID: "mx.example.org",
+		Results: []spf.AuthResult{
+			{Result: spf.PassResult, MailFrom: "info@example.com", Helo: "mail.example.com"},
+			{Result: spf.FailResult, Reason: "not permitted", Helo: "other.example.com"},
+		},
+	}
+
+	expected := "Authentication-Results: mx.example.org; spf=pass\r\n" +
+		"\tsmtp.mailfrom=info@example.com smtp.helo=mail.example.com; spf=fail\r\n" +
+		"\treason=\"not permitted\" smtp.helo=other.example.com"
+
+	if header.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.String())
+	}
+
+	parsed, err := spf.ParseAuthenticationResults(header.String())
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(parsed, header) {
+		t.Errorf("Parsed header differs: %+v", parsed)
+	}
+
+	empty := spf.AuthenticationResults{AuthServID: "mx.example.org"}
+
+	if empty.Value() != "mx.example.org; none" {
+		t.Errorf("Expected 'mx.example.org; none', got '%s' instead", empty.Value())
+	}
+}
+
+func TestAuthenticationResultsControlCharacters(t *testing.T) {
+	header := spf.AuthenticationResults{
+		AuthServID: "mx.example.org\n",
+		Results: []spf.AuthResult{
+			{Result: spf.PassResult, Reason: "ok\x00", MailFrom: "x@evil.com\r\nX-Spam-Flag: NO", Helo: "evil.com\t"},
+		},
+	}
+
+	expected := `"mx.example.org"; spf=pass reason="ok" smtp.mailfrom="x@evil.comX-Spam-Flag: NO" smtp.helo="evil.com"`
+
+	if header.Value() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.Value())
+	}
+
+	if strings.Count(header.String(), "\r\n") != strings.Count(header.String(), "\r\n\t") {
+		t.Errorf("Header contains a line which isn't folded: %q", header.String())
+	}
+}
+
+func TestAuthenticationResultsSRS(t *testing.T) {
+	header := spf.AuthenticationResults{
+		AuthServID: "mx.example.org",
+		Results: []spf.AuthResult{
+			{Result: spf.PassResult, MailFrom: "SRS0=ab=XY=example.com=u@fwd.example.net", Helo: "fwd.example.net"},
+		},
+	}
+
+	expected := "mx.example.org; spf=pass smtp.mailfrom=SRS0=ab=XY=example.com=u@fwd.example.net smtp.helo=fwd.example.net"
+
+	if header.Value() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.Value())
+	}
+
+	parsed, err := spf.ParseAuthenticationResults(header.String())
+
+	if err != nil || !reflect.DeepEqual(parsed, header) {
+		t.Errorf("Parsed header differs: %+v (%v)", parsed, err)
+	}
+}
+
+func TestParseAuthenticationResults(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected spf.AuthenticationResults
+	}{
+		{
+			"example.com; spf=pass smtp.mailfrom=example.net",
+			spf.AuthenticationResults{AuthServID: "example.com", Results: []spf.AuthResult{{Result: spf.PassResult, MailFrom: "example.net"}}},
+		},
+		{
+			// Example of RFC 8601 appendix B.6 with other methods, comments and folding
+			"Authentication-Results: example.com;\r\n" +
+				"\tdkim=pass (good signature) header.d=mail-router.example.net;\r\n" +
+				"\tdkim=fail (bad signature) header.d=newyork.example.com",
+			spf.AuthenticationResults{AuthServID: "example.com"},
+		},
+		{
+			"mx.example.org 1; auth=pass (cram-md5) smtp.auth=sender@example.net;\r\n" +
+				" SPF/1 = Fail (mail from not permitted) smtp.mailfrom=\"odd name\"@example.net;\r\n" +
+				" sender-id=fail header.from=example.com",
+			spf.AuthenticationResults{AuthServID: "mx.example.org", Results: []spf.AuthResult{{Result: spf.FailResult, MailFrom: "odd name@example.net"}}},
+		},
+		{
+			"example.com; spf=pass smtp.mailfrom=bounce+id=x@example.com (forwarded); dkim=pass header.b=ab=cd",
+			spf.AuthenticationResults{AuthServID: "example.com", Results: []spf.AuthResult{{Result: spf.PassResult, MailFrom: "bounce+id=x@example.com"}}},
+		},
+		{
+			"example.org; none",
+			spf.AuthenticationResults{AuthServID: "example.org"},
+		},
+	}
+
+	for _, test := range tests {
+		result, err := spf.ParseAuthenticationResults(test.header)
+
+		if err != nil {
+			t.Errorf("%q: %v", test.header, err)
+		}
+
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("%q: Expected %+v, got %+v", test.header, test.expected, result)
+		}
+	}
+
+	for _, header := range []string{"", "; spf=pass", "example.com spf=pass", "example.com; spf", "example.com; spf=pass smtp.mailfrom", "example.com; spf=pass (comment", `example.com; spf=pass reason="open`, "example.com; spf=bogus", "example.com; spf=policy smtp.mailfrom=example.net"} {
+		if _, err := spf.ParseAuthenticationResults(header); err != spf.ErrInvalidHeader {
+			t.Errorf("%q: Error should be 'invalidheader', got '%v' instead", header, err)
+		}
+	}
+}
@@ -2,13 +2,26 @@ package spf
 
 import "errors"
 
+var ErrNoSPF error = errors.New("nospf")                       // String given to ParseSPF is no spf record
 var ErrSyntax error = errors.New("syntax")                     // Syntax error in ParseSPF
 var ErrInvalidQualifier error = errors.New("invalidqualifier") // Other character than +, -, ~, ? for a qualifier received
 var ErrInvalidMechanism error = errors.New("invalidmechanism") // Unknwon mechanism keyword received
-var ErrInvalidModifier error = errors.New("invalidmodifier")   // Unknown modifier received
+var ErrInvalidModifier error = errors.New("invalidmodifier")   // Modifier with an invalid name received
+var ErrDuplicateModifier = errors.New("duplicatemodifier")     // Record has more than one redirect or exp modifier
 var ErrNotFound error = errors.New("notfound")                 // DNS entry not found
 var ErrOutOfRecursions = errors.New("outofrecursions")         // Too many redirect or include mechanisms were called
+var ErrInvalidMacro = errors.New("invalidmacro")               // Malformed macro in a domain-spec or explanation
+var ErrTooManyLookups = errors.New("toomanylookups")           // An evaluation needed more than 10 dns querying terms
+var ErrTooManyVoidLookups = errors.New("toomanyvoidlookups")   // More than 2 lookups of an evaluation returned no answers
+var ErrMissingRecord = errors.New("missingrecord")             // Target of an include or redirect has no spf record
+var ErrMultipleRecords = errors.New("multiplerecords")         // Domain published more than one spf record
+var ErrTooManyMXHosts = errors.New("toomanymxhosts")           // A mx mechanism resolved to more than 10 exchange hosts
+var ErrInvalidHeader = errors.New("invalidheader")             // Authentication-Results header doesn't follow RFC 8601
+var ErrServerFailure = errors.New("serverfailure")             // Nameserver answered with an error code other than NXDOMAIN
+var ErrInvalidResult = errors.New("invalidresult")             // Unknown result given to Result.UnmarshalJSON
 
 // Most of the time it's the issuers fault an error occurs
 // but this are sadly not the only errors ValidateIP can return
-// DNS Errors (for example if lookup is not available) can also occur
+// DNS Errors (for example if lookup is not available) can also occur.
+// They lead to a temperror result just like ErrServerFailure.
+// The other errors above except ErrNotFound, ErrInvalidHeader and ErrInvalidResult lead to a permerror result
@@ -2,7 +2,10 @@ module github.com/moverval/go-spf
 
 go 1.19
 
-require github.com/miekg/dns v1.1.53
+require (
+	github.com/miekg/dns v1.1.53
+	gopkg.in/yaml.v3 v3.0.1
+)
 
 require (
 	golang.org/x/mod v0.7.0 // indirect
@@ -1,51 +1,343 @@
 package spf
 
 import (
+	"context"
 	"net"
 	"strings"
+	"time"
 )
 
-// Returns the qualifier of a domain for given IP
+// Checker evaluates spf records with the check_host() function of RFC 7208
+type Checker struct {
+	Resolver           Resolver      // Resolver used for every dns query
+	Depth              int           // Number of include and redirect recursions until the checker gives up. DefaultDepth if zero, negative for infinite
+	Receiver           string        // Domain name of the host performing the check, used by the r macro
+	DefaultExplanation string        // Explanation of fail results if the domain has none. The DefaultExplanation constant is used if empty
+	Timeout            time.Duration // Time after which an evaluation gives up with a temperror. Zero for no timeout
+	Strict             bool          // Records are parsed with ParseOptions.Strict, so every syntax error leads to permerror
+}
+
+// Explanation of fail results for domains without exp modifier
+const DefaultExplanation = "%{c} is not allowed to send mail for %{o}"
+
+// Include and redirect recursions of checkers without Depth
+const DefaultDepth = 10
+
+// The arguments of check_host() (RFC 7208 section 4.1) and the state which
+// changes while the records of includes and redirects are evaluated
+type Evaluation struct {
+	Resolver    Resolver
+	IP          net.IP // IP address of the SMTP client
+	Domain      string // Domain whose record is evaluated at the moment
+	Sender      string // MAIL FROM or HELO identity, always with a local part
+	Helo        string // Domain given with HELO or EHLO
+	Depth       int    // Remaining include and redirect recursions
+	Budget      *Budget
+	Receiver    string // Domain name of the host performing the check
+	Explanation string // Explanation of the final fail result, set by CheckHost
+	Strict      bool   // Parse records with ParseOptions.Strict
+
+	exp      string       // exp modifier of the record which failed, resolved once the result is final
+	expEval  *Evaluation  // Evaluation of that record, nil if it has no exp modifier
+	trace    *DomainTrace // Trace of the record evaluated at the moment, nil if the evaluation isn't traced
+	term     *TermTrace   // Trace of the term executed at the moment
+	recorder *traceResolver
+}
+
+// Returns a checker which uses resolver and follows up to DefaultDepth includes and redirects
+func NewChecker(resolver Resolver) *Checker {
+	return &Checker{Resolver: resolver, Depth: DefaultDepth}
+}
+
+// Returns the result of the spf record of a domain for given IP
 //
 // ValidateIP can check number of recursions subrecords until it gives up.
-// To check infinitely, use a negative value
-func ValidateIP(ip net.IP, name string, nameserver string, depth int) (Qualifier, error) {
-	spf, err := LookupSPF(name, nameserver)
+// Zero uses DefaultDepth. To check infinitely, use a negative value
+func ValidateIP(ip net.IP, name string, resolver Resolver, depth int) (Result, error) {
+	return ValidateIPContext(context.Background(), ip, name, resolver, depth)
+}
+
+// Same as ValidateIP, but the evaluation stops with a temperror once ctx is done
+func ValidateIPContext(ctx context.Context, ip net.IP, name string, resolver Resolver, depth int) (Result, error) {
+	checker := Checker{Resolver: resolver, Depth: depth}
+	result, _, err := checker.CheckHost(ctx, ip, name, "postmaster@"+name, "")
+	return result, err
+}
+
+// Evaluates the spf record of domain for ip as described in RFC 7208 section 4
+//
+// sender is the MAIL FROM address. If it is empty, postmaster@helo is used
+// as RFC 7208 section 2.4 requires for null senders.
+// For fail results the explanation of the domain is returned (RFC 7208 section 6.2),
+// for temperror and permerror results the cause is returned as error.
+// If ctx is done or the timeout of the checker passed, dns queries in flight
+// are aborted and the result is a temperror
+func (c *Checker) CheckHost(ctx context.Context, ip net.IP, domain string, sender string, helo string) (Result, string, error) {
+	return c.check(ctx, ip, domain, sender, helo, nil)
+}
+
+// Implementation of CheckHost and Trace. trace is filled if it isn't nil
+func (c *Checker) check(ctx context.Context, ip net.IP, domain string, sender string, helo string, trace *Trace) (Result, string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+
+	if sender == "" {
+		sender = helo
+	}
+
+	if at := strings.LastIndexByte(sender, '@'); at <= 0 {
+		sender = "postmaster@" + sender[at+1:]
+	}
+
+	depth := c.Depth
+
+	if depth == 0 {
+		depth = DefaultDepth
+	}
+
+	// IPv4-mapped IPv6 addresses are treated as IPv4 addresses (RFC 7208 section 5)
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	eval := &Evaluation{
+		Resolver: c.Resolver,
+		IP:       ip,
+		Domain:   domain,
+		Sender:   sender,
+		Helo:     helo,
+		Depth:    depth,
+		Budget:   &Budget{},
+		Receiver: c.Receiver,
+		Strict:   c.Strict,
+	}
+
+	if trace != nil {
+		eval.recorder = &traceResolver{resolver: c.Resolver}
+		eval.Resolver = eval.recorder
+		eval.trace = trace.Root
+
+		defer func() {
+			trace.Lookups, trace.VoidLookups = eval.Budget.Lookups, eval.Budget.VoidLookups
+		}()
+	}
+
+	result, err := checkDomain(ctx, eval)
+
+	if result != FailResult {
+		return result, "", err
+	}
+
+	// Fails of included records only mean no match, so the explanation is
+	// looked up once the result is final (RFC 7208 section 6.2)
+	if eval.expEval != nil {
+		eval.expEval.traceResume()
+		eval.Explanation = explain(ctx, eval.expEval, eval.exp)
+	}
+
+	if eval.Explanation != "" {
+		return result, eval.Explanation, err
+	}
+
+	if trace != nil {
+		// Queries of the default explanation belong to no term
+		eval.recorder.queries = &trace.Queries
+	}
+
+	explanation := c.DefaultExplanation
+
+	if explanation == "" {
+		explanation = DefaultExplanation
+	}
+
+	if expanded, err := ExpandExplanation(ctx, eval, explanation); err == nil {
+		explanation = expanded
+	}
+
+	return result, explanation, err
+}
+
+// Checks the HELO identity as described in RFC 7208 section 2.3
+func (c *Checker) CheckHelo(ctx context.Context, ip net.IP, helo string) (Result, string, error) {
+	return c.CheckHost(ctx, ip, helo, "postmaster@"+helo, helo)
+}
+
+// Looks up and evaluates the record of eval.Domain. This is the interpreter loop of CheckHost
+func checkDomain(ctx context.Context, eval *Evaluation) (result Result, err error) {
+	eval.traceDomain()
+	defer func() { eval.traceResult(result, err) }()
+
+	if !isDomainName(eval.Domain) {
+		return NoneResult, nil
+	}
+
+	spf, err := lookupSPF(ctx, eval.Domain, eval.Resolver)
 
 	if err != nil {
 		if err == ErrNotFound {
-			return NoneQualifier, nil
+			return NoneResult, nil
 		}
 
-		return 0, err
+		return errorResult(err), err
 	}
 
-	record, err := ParseSPF(spf)
+	eval.traceRecord(spf)
+	record, warnings, err := ParseOptions{Strict: eval.Strict}.ParseSPF(spf)
 
 	if err != nil {
-		return NoneQualifier, err
+		return errorResult(err), err
 	}
 
-	for _, mechanism := range record {
-		qualifier, err := ExecuteMechanism(ip, mechanism, nameserver, depth)
+	eval.traceWarnings(warnings)
+
+	var redirect *Mechanism
+
+	for i, mechanism := range record {
+		// Resolvers which don't watch ctx are stopped between the mechanisms
+		if err := ctx.Err(); err != nil {
+			return TempErrorResult, err
+		}
+
+		if mechanism.Mechanism == RedirectMechanism {
+			redirect = &record[i]
+		}
+
+		if mechanism.IsModifier() {
+			continue
+		}
+
+		eval.traceTerm(mechanism)
+		result, err := ExecuteMechanism(ctx, eval, mechanism)
+		eval.traceTermResult(result, err)
 
 		if err != nil {
-			return NoneQualifier, err
+			return result, err
+		}
+
+		if result == FailResult {
+			for _, modifier := range record {
+				if modifier.Mechanism == ExpMechanism {
+					eval.exp, eval.expEval = modifier.Value, eval
+				}
+			}
 		}
 
-		if qualifier != NoneQualifier {
-			return qualifier, nil
+		if result != NoneResult {
+			return result, nil
 		}
 	}
 
-	return NoneQualifier, nil
+	// A redirect is only followed if no mechanism matched. Because an all
+	// mechanism always matches, redirects of records with one are ignored
+	if redirect != nil {
+		eval.traceTerm(*redirect)
+		result, err := ExecuteMechanism(ctx, eval, *redirect)
+		eval.traceTermResult(result, err)
+		return result, err
+	}
+
+	return NeutralResult, nil
 }
 
-// Make exact queries or execute a part of a record. This is used by ValidateIP
-func ExecuteMechanism(ip net.IP, mechanism Mechanism, nameserver string, depth int) (Qualifier, error) {
+// Returns the explanation of the domain-spec of an exp modifier or an empty
+// string if it couldn't be retrieved (RFC 7208 section 6.2)
+func explain(ctx context.Context, eval *Evaluation, exp string) string {
+	domain, err := ExpandDomainSpec(ctx, eval, exp)
+
+	if err != nil || domain == "" {
+		return ""
+	}
+
+	texts, err := eval.Resolver.LookupTXT(ctx, domain)
+
+	// Control characters like CRLF would end up in the SMTP reply
+	if err != nil || len(texts) != 1 || !isExplanationString(texts[0]) {
+		return ""
+	}
+
+	explanation, err := ExpandExplanation(ctx, eval, texts[0])
+
+	if err != nil {
+		return ""
+	}
+
+	return explanation
+}
+
+// Returns a copy of eval which evaluates the record of domain one recursion deeper
+func (eval *Evaluation) sub(domain string) *Evaluation {
+	sub := *eval
+	sub.Domain = domain
+	sub.Depth--
+
+	if eval.trace != nil {
+		sub.trace = &DomainTrace{Domain: domain}
+		eval.term.Target = sub.trace
+	}
+
+	return &sub
+}
+
+// Checks if name is a fully qualified domain name with at least two labels,
+// none of them empty or longer than 63 characters
+func isDomainName(name string) bool {
+	name = strings.TrimSuffix(name, ".")
+
+	if len(name) > 253 || !strings.Contains(name, ".") {
+		return false
+	}
+
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Make exact queries or execute a part of a record. This is used by CheckHost
+//
+// Returns the result of the qualifier if the mechanism matches and none if it doesn't.
+// A redirect returns the result of its target and should only be executed
+// after no mechanism of the record matched
+func ExecuteMechanism(ctx context.Context, eval *Evaluation, mechanism Mechanism) (Result, error) {
+	ip := eval.IP
+	domain := mechanism.Value
+
+	switch mechanism.Mechanism {
+	case AMechanism, MXMechanism, PTRMechanism, ExistsMechanism, IncludeMechanism, RedirectMechanism:
+		// These mechanisms query dns and their values are domain-specs which can contain macros
+		if err := eval.Budget.lookup(); err != nil {
+			return PermErrorResult, err
+		}
+
+		expanded, err := ExpandDomainSpec(ctx, eval, mechanism.Value)
+
+		if err != nil {
+			return errorResult(err), err
+		}
+
+		domain = expanded
+		eval.traceTarget(domain)
+	}
+
+	if domain == "" {
+		switch mechanism.Mechanism {
+		case AMechanism, MXMechanism, PTRMechanism:
+			// Without domain-spec the current domain is used, which changes with include and redirect
+			domain = eval.Domain
+		case ExistsMechanism, IncludeMechanism, RedirectMechanism:
+			return PermErrorResult, ErrSyntax
+		}
+	}
+
 	switch mechanism.Mechanism {
 	case AllMechanism:
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 	case IPv4Mechanism, IPv6Mechanism:
 		// Small and simple mechanism (fast to check)
 		match, err := MatchIPWithCIDR(ip, mechanism.Value)
@@ -54,139 +346,115 @@ func ExecuteMechanism(ip net.IP, mechanism Mechanism, nameserver string, depth i
 			equal, err := MatchIP(ip, mechanism.Value)
 
 			if err != nil {
-				return NoneQualifier, err
+				return errorResult(err), err
 			}
 
 			if equal {
-				return mechanism.Qualifier, nil
+				return mechanism.Qualifier.Result(), nil
 			} else {
-				return NoneQualifier, nil
+				return NoneResult, nil
 			}
 		}
 
 		if !match {
-			return NoneQualifier, nil
+			return NoneResult, nil
 		}
 
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 
 	case AMechanism:
 		// Good alternative to ip mechanisms
-		match, err := MatchIPWithARec(ip, mechanism.Value, nameserver)
+		ip4CIDR, ip6CIDR := mechanism.CIDRLengths()
+		match, err := eval.matchA(ctx, domain, ip4CIDR, ip6CIDR)
 
 		if err != nil {
-			return NoneQualifier, err
+			return errorResult(err), err
 		}
 
 		if !match {
-			return NoneQualifier, nil
+			return NoneResult, nil
 		}
 
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 	case MXMechanism:
 		// Can have a lot of lookups :/
-		match, err := MatchIPWithMXRec(ip, mechanism.Value, nameserver)
+		ip4CIDR, ip6CIDR := mechanism.CIDRLengths()
+		match, err := eval.matchMX(ctx, domain, ip4CIDR, ip6CIDR)
 
 		if err != nil {
-			return NoneQualifier, err
+			return errorResult(err), err
 		}
 
 		if !match {
-			return NoneQualifier, nil
+			return NoneResult, nil
 		}
 
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 	case PTRMechanism:
 		// Can be time hungry :/
-		match, err := MatchIPWithPtrRec(ip, mechanism.Value, nameserver)
+		match, err := eval.matchPTR(ctx, domain)
 
 		if err != nil {
-			return NoneQualifier, err
+			return errorResult(err), err
 		}
 
 		if !match {
-			return NoneQualifier, nil
+			return NoneResult, nil
 		}
 
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 	case ExistsMechanism:
 		// Complex mechanism (like if statement)
-		query := strings.Replace(mechanism.Value, "%{i}", ip.String(), -1)
-
-		resolved, err := LookupARec(query, nameserver)
+		match, err := eval.exists(ctx, domain)
 
 		if err != nil {
-			return NoneQualifier, err
+			return errorResult(err), err
 		}
 
-		if resolved == nil {
-			return NoneQualifier, nil
+		if !match {
+			return NoneResult, nil
 		}
 
-		return mechanism.Qualifier, nil
+		return mechanism.Qualifier.Result(), nil
 	case RedirectMechanism:
-		// Redirect and include behave the same when executed
-		if depth == 0 {
-			return NoneQualifier, ErrOutOfRecursions
-		}
-
-		spf, err := LookupSPF(mechanism.Value, nameserver)
-
-		if err != nil {
-			return NoneQualifier, err
+		// The result of the target record becomes the result of the current one
+		if eval.Depth == 0 {
+			return PermErrorResult, ErrOutOfRecursions
 		}
 
-		parsedSpf, err := ParseSPF(spf)
+		sub := eval.sub(domain)
+		result, err := checkDomain(ctx, sub)
+		eval.traceResume()
 
-		if err != nil {
-			return NoneQualifier, err
+		if result == NoneResult {
+			return PermErrorResult, ErrMissingRecord
 		}
 
-		for _, mechanism := range parsedSpf {
-			result, err := ExecuteMechanism(ip, mechanism, nameserver, depth-1)
-
-			if err != nil {
-				return NoneQualifier, err
-			}
-
-			if result != NoneQualifier {
-				return result, nil
-			}
-		}
+		// Only the exp modifier of the target explains a fail
+		eval.exp, eval.expEval = sub.exp, sub.expEval
+		return result, err
 	case IncludeMechanism:
-		// Redirect and include behave the same when executed
-		if depth == 0 {
-			return NoneQualifier, ErrOutOfRecursions
-		}
-
-		spf, err := LookupSPF(mechanism.Value, nameserver)
-
-		if err != nil {
-			return NoneQualifier, err
-		}
-
-		parsedSpf, err := ParseSPF(spf)
-
-		if err != nil {
-			return NoneQualifier, err
+		// The include matches if the record of the target passes (RFC 7208 section 5.2)
+		if eval.Depth == 0 {
+			return PermErrorResult, ErrOutOfRecursions
 		}
 
-		for _, mechanism := range parsedSpf {
-			result, err := ExecuteMechanism(ip, mechanism, nameserver, depth-1)
-
-			if err != nil {
-				return NoneQualifier, err
-			}
-
-			if result == PassQualifier {
-				return mechanism.Qualifier, nil
-			}
+		result, err := checkDomain(ctx, eval.sub(domain))
+		eval.traceResume()
+
+		switch result {
+		case PassResult:
+			return mechanism.Qualifier.Result(), nil
+		case FailResult, SoftFailResult, NeutralResult:
+			return NoneResult, nil
+		case NoneResult:
+			return PermErrorResult, ErrMissingRecord
+		default:
+			return result, err
 		}
-
-		return NoneQualifier, nil
 	}
 
-	return NoneQualifier, nil
+	return NoneResult, nil
 }
 
 // Check if ip exists in a network
@@ -1,75 +1,714 @@
 package spf_test
 
 import (
+	"context"
+	"errors"
 	"net"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
 )
 
+// Records of the domains these tests used to query on 8.8.8.8, so they run offline
+var snapshotZone = &spftest.Zone{
+	TXT: map[string][]string{
+		"gmail.com":                  {"globalsign-smime-dv=CDYX+XFHUw2wml6/Gb8+59BsH31KzUr6c1l2BPvqKX8=", "v=spf1 redirect=_spf.google.com"},
+		"_spf.google.com":            {"v=spf1 include:_netblocks.google.com include:_netblocks2.google.com include:_netblocks3.google.com ~all"},
+		"_netblocks.google.com":      {"v=spf1 ip4:35.190.247.0/24 ip4:64.233.160.0/19 ip4:66.102.0.0/20 ip4:66.249.80.0/20 ip4:72.14.192.0/18 ip4:74.125.0.0/16 ip4:108.177.8.0/21 ip4:173.194.0.0/16 ip4:209.85.128.0/17 ip4:216.58.192.0/19 ip4:216.239.32.0/19 ~all"},
+		"_netblocks2.google.com":     {"v=spf1 ip6:2001:4860:4000::/36 ip6:2404:6800:4000::/36 ip6:2607:f8b0:4000::/36 ip6:2800:3f0:4000::/36 ip6:2a00:1450:4000::/36 ip6:2c0f:fb50:4000::/36 ~all"},
+		"_netblocks3.google.com":     {"v=spf1 ip4:172.217.0.0/19 ip4:172.217.32.0/20 ip4:172.217.128.0/19 ip4:172.217.160.0/20 ip4:172.217.192.0/19 ip4:172.253.56.0/21 ip4:172.253.112.0/20 ip4:108.177.96.0/19 ip4:35.191.0.0/16 ip4:130.211.0.0/22 ~all"},
+		"nsa.gov":                    {"v=spf1 ip4:8.44.101.0/24 include:spf.protection.outlook.com ~all"},
+		"spf.protection.outlook.com": {"v=spf1 ip4:40.92.0.0/15 ip4:40.107.0.0/16 ip4:52.100.0.0/14 ip4:104.47.0.0/17 ip6:2a01:111:f400::/48 ip6:2a01:111:f403::/49 -all"},
+		"privateemail.com":           {"v=spf1 ip4:198.54.122.0/24 ip4:198.54.127.0/24 ip4:68.65.122.0/24 -all"},
+	},
+}
+
 func TestValidationPass(t *testing.T) {
 	// Check if google mail server can send mail from gmail.com
-	result, err := spf.ValidateIP(net.ParseIP("35.190.247.10"), "gmail.com", "8.8.8.8:53", 10)
+	result, err := spf.ValidateIP(net.ParseIP("35.190.247.10"), "gmail.com", snapshotZone, 10)
 
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if result != spf.PassQualifier {
-		t.Errorf("False Qualifier. Expected %q, got %q", spf.PassQualifier, result)
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
 	}
 }
 
 func TestInclude(t *testing.T) {
-	result, err := spf.ValidateIP(net.ParseIP("127.0.0.1"), "nsa.gov", "8.8.8.8:53", 10)
+	result, err := spf.ValidateIP(net.ParseIP("127.0.0.1"), "nsa.gov", snapshotZone, 10)
 
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if result != spf.SoftFailQualifier {
-		t.Errorf("False Qualifier. Expected %q, got %q", spf.SoftFailQualifier, result)
+	if result != spf.SoftFailResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.SoftFailResult, result)
 	}
 }
 
 func TestIp6(t *testing.T) {
-	result, err := spf.ValidateIP(net.ParseIP("::1"), "nsa.gov", "8.8.8.8:53", 10)
+	result, err := spf.ValidateIP(net.ParseIP("::1"), "nsa.gov", snapshotZone, 10)
 
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if result != spf.SoftFailQualifier {
-		t.Errorf("False Qualifier. Expected %q, got %q", spf.SoftFailQualifier, result)
+	if result != spf.SoftFailResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.SoftFailResult, result)
 	}
 }
 
 func TestIpv4IP(t *testing.T) {
-	result, err := spf.ValidateIP(net.ParseIP("::1"), "privateemail.com", "8.8.8.8:53", 10)
+	result, err := spf.ValidateIP(net.ParseIP("::1"), "privateemail.com", snapshotZone, 10)
 
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if result != spf.FailQualifier {
-		t.Errorf("False Qualifier. Expected %q, got %q", spf.FailQualifier, result)
+	if result != spf.FailResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.FailResult, result)
 	}
 }
 
 func TestValidationSoftFail(t *testing.T) {
 	// Check if local ip can send mail as gmail.com
-	result, err := spf.ValidateIP(net.ParseIP("192.168.178.50"), "gmail.com", "8.8.8.8:53", 10)
+	result, err := spf.ValidateIP(net.ParseIP("192.168.178.50"), "gmail.com", snapshotZone, 10)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != spf.SoftFailResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.SoftFailResult, result)
+	}
+}
+
+func TestValidationResolver(t *testing.T) {
+	resolver := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 include:spf.voulter.com ~all"},
+			"spf.voulter.com": {"v=spf1 ip4:192.0.2.0/24 -all"},
+		},
+	}
+
+	result, err := spf.ValidateIP(net.ParseIP("192.0.2.10"), "voulter.com", resolver, 10)
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 include:spf.voulter.com -all"},
+			"spf.voulter.com": {"v=spf1 ip4:192.0.2.0/24 ip6:2001:db8::/32 -all"},
+		},
+	})
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("2001:db8::25"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+}
+
+func TestCheckHostMalformedDomain(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"com": {"v=spf1 -all"},
+		},
+	})
+
+	for _, domain := range []string{"com", "voulter..com", "", strings.Repeat("a", 64) + ".com"} {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), domain, "", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+
+		if result != spf.NoneResult {
+			t.Errorf("False Result for '%s'. Expected %q, got %q", domain, spf.NoneResult, result)
+		}
+	}
+}
+
+func TestCheckHelo(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"mail.voulter.com": {"v=spf1 ip4:192.0.2.25 -all"},
+		},
+	})
+
+	result, _, err := checker.CheckHelo(context.Background(), net.ParseIP("192.0.2.26"), "mail.voulter.com")
 
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	if result != spf.SoftFailQualifier {
-		t.Errorf("False Qualifier. Expected %q, got %q", spf.SoftFailQualifier, result)
+	if result != spf.FailResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.FailResult, result)
+	}
+}
+
+func TestCheckHostNeutral(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 ip4:192.0.2.0/24"},
+		},
+	})
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("198.51.100.1"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != spf.NeutralResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.NeutralResult, result)
+	}
+}
+
+func TestCheckHostTempError(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 a:mail.voulter.com -all"},
+		},
+		Errors: map[string]error{
+			"mail.voulter.com": spf.ErrServerFailure,
+		},
+	})
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.TempErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.TempErrorResult, result)
+	}
+
+	if err != spf.ErrServerFailure {
+		t.Errorf("Error should be 'serverfailure', got '%v' instead", err)
+	}
+}
+
+func TestCheckHostPermError(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 ip4:192.0.2.10 foo:bar -all"},
+		},
+	})
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PermErrorResult, result)
+	}
+
+	if err != spf.ErrInvalidMechanism {
+		t.Errorf("Error should be 'invalidmechanism', got '%v' instead", err)
+	}
+}
+
+func TestCheckHostMacros(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 exists:%{l}.%{o}.%{ir}._spf.%{d} -all"},
+		},
+		A: map[string][]net.IP{
+			"postmaster.mail.voulter.com.10.2.0.192._spf.voulter.com": {net.ParseIP("127.0.0.2")},
+		},
+	})
+
+	// The null sender is replaced by postmaster@helo
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+}
+
+func TestCheckHostRedirect(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":      {"v=spf1 redirect=_spf.voulter.com ip4:192.0.2.10"},
+			"_spf.voulter.com": {"v=spf1 ip4:198.51.100.0/24"},
+			"all.voulter.com":  {"v=spf1 redirect=missing.voulter.com ?all"},
+			"none.voulter.com": {"v=spf1 redirect=missing.voulter.com"},
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+	}{
+		{"192.0.2.10", "voulter.com", spf.PassResult},        // Mechanisms are evaluated before the redirect
+		{"192.0.2.11", "voulter.com", spf.NeutralResult},     // Result of the target becomes the result
+		{"192.0.2.11", "all.voulter.com", spf.NeutralResult}, // Redirect is ignored if all is present
+	}
+
+	for _, test := range tests {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		if result != test.expected {
+			t.Errorf("False Result for %s. Expected %q, got %q", test.domain, test.expected, result)
+		}
+	}
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.11"), "none.voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PermErrorResult, result)
+	}
+
+	if err != spf.ErrMissingRecord {
+		t.Errorf("Error should be 'missingrecord', got '%v' instead", err)
+	}
+}
+
+func TestCheckHostInclude(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":       {"v=spf1 include:ban.voulter.com include:spf.voulter.com ~all"},
+			"ban.voulter.com":   {"v=spf1 -ip4:192.0.2.10 +all"},
+			"spf.voulter.com":   {"v=spf1 ip4:192.0.2.0/24 -all"},
+			"minus.voulter.com": {"v=spf1 -include:spf.voulter.com +all"},
+			"temp.voulter.com":  {"v=spf1 include:fail.voulter.com +all"},
+			"none.voulter.com":  {"v=spf1 include:missing.voulter.com +all"},
+		},
+		Errors: map[string]error{
+			"fail.voulter.com": spf.ErrServerFailure,
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+		err      error
+	}{
+		{"192.0.2.10", "voulter.com", spf.PassResult, nil},         // Fail of the first include is no match
+		{"192.0.2.11", "voulter.com", spf.PassResult, nil},         // Pass of the first include is a match
+		{"198.51.100.1", "voulter.com", spf.PassResult, nil},       // +all of the first include matches
+		{"192.0.2.10", "minus.voulter.com", spf.FailResult, nil},   // Qualifier of the include is used on a match
+		{"198.51.100.1", "minus.voulter.com", spf.PassResult, nil}, // Fail of the target is no match
+		{"192.0.2.10", "temp.voulter.com", spf.TempErrorResult, spf.ErrServerFailure},
+		{"192.0.2.10", "none.voulter.com", spf.PermErrorResult, spf.ErrMissingRecord},
+	}
+
+	for _, test := range tests {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != test.err {
+			t.Errorf("Error for %s should be '%v', got '%v' instead", test.domain, test.err, err)
+		}
+
+		if result != test.expected {
+			t.Errorf("False Result for %s and %s. Expected %q, got %q", test.ip, test.domain, test.expected, result)
+		}
+	}
+}
+
+func TestCheckHostZeroDepth(t *testing.T) {
+	zone := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 include:spf.voulter.com -all"},
+			"spf.voulter.com": {"v=spf1 +all"},
+		},
+	}
+
+	checker := spf.Checker{Resolver: zone}
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PassResult || err != nil {
+		t.Errorf("Checker without Depth should follow includes, got %q with '%v'", result, err)
+	}
+}
+
+func TestCheckHostExplanation(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":          {"v=spf1 exp=explain.voulter.com ip4:192.0.2.0/24 -all"},
+			"explain.voulter.com":  {"%{i} is not one of %{d}'s designated mail servers, rejected by %{r} for %{c}"},
+			"redirect.voulter.com": {"v=spf1 exp=missing.voulter.com redirect=voulter.com"},
+			"default.voulter.com":  {"v=spf1 -all"},
+		},
+	})
+
+	checker.Receiver = "mx.example.org"
+
+	tests := []struct {
+		domain      string
+		explanation string
+	}{
+		{"voulter.com", "2.0.0.1.0.d.b.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1 is not one of voulter.com's designated mail servers, rejected by mx.example.org for 2001:db8::1"},
+		{"redirect.voulter.com", "2.0.0.1.0.d.b.8.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.1 is not one of voulter.com's designated mail servers, rejected by mx.example.org for 2001:db8::1"},
+		{"default.voulter.com", "2001:db8::1 is not allowed to send mail for voulter.com"},
+	}
+
+	for _, test := range tests {
+		result, explanation, err := checker.CheckHost(context.Background(), net.ParseIP("2001:db8::1"), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		if result != spf.FailResult {
+			t.Errorf("False Result for %s. Expected %q, got %q", test.domain, spf.FailResult, result)
+		}
+
+		if explanation != test.explanation {
+			t.Errorf("Not as expected: '%s' does not equal to '%s'", explanation, test.explanation)
+		}
+	}
+
+	checker.DefaultExplanation = "DEFAULT"
+	_, explanation, _ := checker.CheckHost(context.Background(), net.ParseIP("2001:db8::1"), "default.voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if explanation != "DEFAULT" {
+		t.Errorf("Not as expected: '%s' does not equal to 'DEFAULT'", explanation)
+	}
+
+	_, explanation, _ = checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if explanation != "" {
+		t.Errorf("Pass results should have no explanation, got '%s'", explanation)
+	}
+}
+
+func TestCheckHostExplanationControlCharacters(t *testing.T) {
+	zone := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 exp=explain.voulter.com -all"},
+		},
+	}
+
+	checker := spf.NewChecker(zone)
+	checker.DefaultExplanation = "DEFAULT"
+
+	for _, text := range []string{"denied\r\n250 2.0.0 Ok: queued", "denied\nfor %{i}", "verweigert f\xc3\xbcr %{i}", "denied\tfor %{i}"} {
+		zone.TXT["explain.voulter.com"] = []string{text}
+		_, explanation, _ := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+		if explanation != "DEFAULT" {
+			t.Errorf("%q: Expected the default explanation, got '%s'", text, explanation)
+		}
+	}
+}
+
+// Zone which remembers the names of all TXT queries
+type txtLog struct {
+	*spftest.Zone
+	names []string
+}
+
+func (l *txtLog) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	l.names = append(l.names, name)
+	return l.Zone.LookupTXT(ctx, name)
+}
+
+func TestCheckHostIncludeExplanation(t *testing.T) {
+	zone := &txtLog{Zone: &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":         {"v=spf1 exp=explain.voulter.com include:spf.voulter.com -all"},
+			"spf.voulter.com":     {"v=spf1 exp=explain.spf.voulter.com -all"},
+			"explain.voulter.com": {"denied by voulter.com"},
+		},
+	}}
+
+	result, explanation, _ := spf.NewChecker(zone).CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.FailResult || explanation != "denied by voulter.com" {
+		t.Errorf("Expected fail explained by voulter.com, got %q with '%s'", result, explanation)
+	}
+
+	expected := []string{"voulter.com", "spf.voulter.com", "explain.voulter.com"}
+
+	if !reflect.DeepEqual(zone.names, expected) {
+		t.Errorf("Expected TXT queries %q, got %q", expected, zone.names)
+	}
+}
+
+func TestCheckHostUnknownModifiers(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":           {"v=spf1 ra=postmaster rp=100 ip4:192.0.2.10 -all"},
+			"duplicate.voulter.com": {"v=spf1 redirect=voulter.com redirect=voulter.com"},
+		},
+	})
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+
+	result, _, err = checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "duplicate.voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult || err != spf.ErrDuplicateModifier {
+		t.Errorf("False Result. Expected %q with 'duplicatemodifier', got %q with '%v'", spf.PermErrorResult, result, err)
+	}
+}
+
+func TestCheckHostDualCIDR(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"a.voulter.com":  {"v=spf1 a:mail.voulter.com/24 -all"},
+			"mx.voulter.com": {"v=spf1 mx:voulter.com/28 -all"},
+		},
+		A: map[string][]net.IP{
+			"mail.voulter.com": {net.ParseIP("192.0.2.10")},
+		},
+		MX: map[string][]string{
+			"voulter.com": {"mail.voulter.com"},
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+	}{
+		{"192.0.2.200", "a.voulter.com", spf.PassResult},
+		{"192.0.3.10", "a.voulter.com", spf.FailResult},
+		{"192.0.2.15", "mx.voulter.com", spf.PassResult},
+		{"192.0.2.16", "mx.voulter.com", spf.FailResult},
+	}
+
+	for _, test := range tests {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		if result != test.expected {
+			t.Errorf("False Result for %s and %s. Expected %q, got %q", test.ip, test.domain, test.expected, result)
+		}
+	}
+}
+
+func TestExecuteMechanismDefaultCIDR(t *testing.T) {
+	eval := &spf.Evaluation{
+		Resolver: &spftest.Zone{A: map[string][]net.IP{"voulter.com": {net.ParseIP("192.0.2.10")}}},
+		IP:       net.ParseIP("203.0.113.99"),
+		Domain:   "voulter.com",
+	}
+
+	mechanism := spf.Mechanism{Qualifier: spf.PassQualifier, Mechanism: spf.AMechanism, Value: "voulter.com"}
+	result, err := spf.ExecuteMechanism(context.Background(), eval, mechanism)
+
+	if err != nil || result != spf.NoneResult {
+		t.Errorf("Mechanism without prefix lengths should compare whole addresses, got %q with '%v'", result, err)
+	}
+
+	if mechanism.String() != "a:voulter.com" {
+		t.Errorf("Unexpected term %s", mechanism.String())
+	}
+
+	mechanism.IP4CIDR = spf.CIDRLength(0)
+	result, err = spf.ExecuteMechanism(context.Background(), eval, mechanism)
+
+	if err != nil || result != spf.PassResult || mechanism.String() != "a:voulter.com/0" {
+		t.Errorf("Expected %s to match every address, got %q with '%v'", mechanism.String(), result, err)
+	}
+}
+
+func TestCheckHostIPv6(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"a.voulter.com":      {"v=spf1 a:mail.voulter.com//64 -all"},
+			"mx.voulter.com":     {"v=spf1 mx:voulter.com -all"},
+			"exists.voulter.com": {"v=spf1 exists:%{ir}.%{v}._spf.voulter.com -all"},
+		},
+		A: map[string][]net.IP{
+			"mail.voulter.com": {net.ParseIP("192.0.2.10")},
+			"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.voulter.com": {net.ParseIP("127.0.0.2")},
+		},
+		AAAA: map[string][]net.IP{
+			"mail.voulter.com":   {net.ParseIP("2001:db8::10")},
+			"backup.voulter.com": {net.ParseIP("2001:db8:1::25")},
+		},
+		MX: map[string][]string{
+			"voulter.com": {"backup.voulter.com"},
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+	}{
+		{"2001:db8::ffff", "a.voulter.com", spf.PassResult},
+		{"2001:db8:1::10", "a.voulter.com", spf.FailResult},
+		{"::ffff:192.0.2.10", "a.voulter.com", spf.PassResult}, // IPv4-mapped addresses use a records
+		{"2001:db8:1::25", "mx.voulter.com", spf.PassResult},
+		{"2001:db8::1", "exists.voulter.com", spf.PassResult},
+		{"2001:db8::2", "exists.voulter.com", spf.FailResult},
+	}
+
+	for _, test := range tests {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		if result != test.expected {
+			t.Errorf("False Result for %s and %s. Expected %q, got %q", test.ip, test.domain, test.expected, result)
+		}
+	}
+}
+
+func TestCheckHostDefaultDomain(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 a include:spf.voulter.com -all"},
+			"spf.voulter.com": {"v=spf1 mx/24 -all"},
+			"bad.voulter.com": {"v=spf1 include -all"},
+		},
+		A: map[string][]net.IP{
+			"voulter.com":      {net.ParseIP("192.0.2.10")},
+			"mail.voulter.com": {net.ParseIP("198.51.100.25")},
+		},
+		MX: map[string][]string{
+			"spf.voulter.com": {"mail.voulter.com"},
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+	}{
+		{"192.0.2.10", "voulter.com", spf.PassResult},   // a uses voulter.com
+		{"198.51.100.1", "voulter.com", spf.PassResult}, // mx uses spf.voulter.com inside the include
+		{"203.0.113.1", "voulter.com", spf.FailResult},  // Neither matches
+		{"192.0.2.10", "bad.voulter.com", spf.PermErrorResult},
+	}
+
+	for _, test := range tests {
+		result, _, _ := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if result != test.expected {
+			t.Errorf("False Result for %s and %s. Expected %q, got %q", test.ip, test.domain, test.expected, result)
+		}
+	}
+}
+
+func TestCheckHostPTR(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":      {"v=spf1 ptr -all"},
+			"fake.voulter.com": {"v=spf1 ptr:fake.voulter.com -all"},
+			"other.com":        {"v=spf1 ptr -all"},
+		},
+		PTR: map[string][]string{
+			"10.2.0.192.in-addr.arpa": {"mail.voulter.com.", "fake.voulter.com."},
+			"5.2.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6.arpa": {"MAIL6.voulter.com."},
+		},
+		A: map[string][]net.IP{
+			"mail.voulter.com": {net.ParseIP("192.0.2.10")},
+			"fake.voulter.com": {net.ParseIP("198.51.100.1")},
+		},
+		AAAA: map[string][]net.IP{
+			"mail6.voulter.com": {net.ParseIP("2001:db8::25")},
+		},
+	})
+
+	tests := []struct {
+		ip       string
+		domain   string
+		expected spf.Result
+	}{
+		{"192.0.2.10", "voulter.com", spf.PassResult},      // mail.voulter.com is a validated subdomain
+		{"192.0.2.10", "fake.voulter.com", spf.FailResult}, // fake.voulter.com doesn't resolve back
+		{"192.0.2.10", "other.com", spf.FailResult},
+		{"2001:db8::25", "voulter.com", spf.PassResult},
+	}
+
+	for _, test := range tests {
+		result, _, err := checker.CheckHost(context.Background(), net.ParseIP(test.ip), test.domain, "info@voulter.com", "mail.voulter.com")
+
+		if err != nil {
+			t.Error(err)
+		}
+
+		if result != test.expected {
+			t.Errorf("False Result for %s and %s. Expected %q, got %q", test.ip, test.domain, test.expected, result)
+		}
+	}
+}
+
+func TestCheckHostTimeout(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT:    map[string][]string{"voulter.com": {"v=spf1 a:mail.voulter.com -all"}},
+		Errors: map[string]error{"mail.voulter.com": spftest.ErrTimeout},
+	})
+	checker.Timeout = 10 * time.Millisecond
+
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.TempErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.TempErrorResult, result)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Error should be the deadline of the context, got '%v' instead", err)
+	}
+}
+
+func TestValidateIPContextCancelled(t *testing.T) {
+	resolver := &spftest.Zone{
+		TXT: map[string][]string{"voulter.com": {"v=spf1 ip4:198.51.100.1 ptr -all"}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	result, err := spf.ValidateIPContext(ctx, net.ParseIP("192.0.2.10"), "voulter.com", resolver, 10)
+
+	if result != spf.TempErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.TempErrorResult, result)
+	}
+
+	if err != context.Canceled {
+		t.Errorf("Error should be 'context canceled', got '%v' instead", err)
 	}
 }
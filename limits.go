@@ -0,0 +1,50 @@
+package spf
+
+// Limits of a single evaluation as required by RFC 7208 section 4.6.4
+const (
+	MaxLookups     = 10 // Terms which query dns, including those of includes and redirects
+	MaxVoidLookups = 2  // Lookups which returned NXDOMAIN or no answers
+	MaxMXHosts     = 10 // Exchange hosts of a single mx mechanism whose addresses are looked up
+)
+
+// Counts the dns lookups of an evaluation. The records of all includes and
+// redirects share the budget of the evaluation they were reached from.
+// A nil budget doesn't enforce any limit
+type Budget struct {
+	Lookups     int // Terms which queried dns so far
+	VoidLookups int // Lookups without answers so far
+}
+
+// Counts a term which queries dns
+//
+// Returns ErrTooManyLookups if the term exceeds MaxLookups
+func (b *Budget) lookup() error {
+	if b == nil {
+		return nil
+	}
+
+	b.Lookups++
+
+	if b.Lookups > MaxLookups {
+		return ErrTooManyLookups
+	}
+
+	return nil
+}
+
+// Counts a void lookup if a lookup returned no answers
+//
+// Returns ErrTooManyVoidLookups if the lookup exceeds MaxVoidLookups
+func (b *Budget) void(answers int) error {
+	if b == nil || answers > 0 {
+		return nil
+	}
+
+	b.VoidLookups++
+
+	if b.VoidLookups > MaxVoidLookups {
+		return ErrTooManyVoidLookups
+	}
+
+	return nil
+}
@@ -0,0 +1,117 @@
+package spf_test
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+)
+
+func TestLookupLimit(t *testing.T) {
+	resolver := &spftest.Zone{TXT: map[string][]string{}}
+
+	// Every include and a mechanism counts, the limit is hit in the fourth record
+	for i := 0; i < 5; i++ {
+		resolver.TXT[fmt.Sprintf("spf%d.voulter.com", i)] = []string{fmt.Sprintf("v=spf1 a:a.voulter.com a:b.voulter.com include:spf%d.voulter.com", i+1)}
+	}
+
+	resolver.A = map[string][]net.IP{
+		"a.voulter.com": {net.ParseIP("198.51.100.1")},
+		"b.voulter.com": {net.ParseIP("198.51.100.2")},
+	}
+
+	checker := &spf.Checker{Resolver: resolver, Depth: -1}
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "spf0.voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PermErrorResult, result)
+	}
+
+	if err != spf.ErrTooManyLookups {
+		t.Errorf("Error should be 'toomanylookups', got '%v' instead", err)
+	}
+}
+
+func TestLookupLimitNotReached(t *testing.T) {
+	resolver := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:a.voulter.com a:b.voulter.com -all"},
+		},
+		A: map[string][]net.IP{
+			"a.voulter.com": {net.ParseIP("198.51.100.1")},
+			"b.voulter.com": {net.ParseIP("192.0.2.10")},
+		},
+	}
+
+	result, _, err := spf.NewChecker(resolver).CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+}
+
+func TestVoidLookupLimit(t *testing.T) {
+	resolver := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com": {"v=spf1 a:none1.voulter.com mx:none2.voulter.com exists:none3.voulter.com -all"},
+		},
+	}
+
+	result, _, err := spf.NewChecker(resolver).CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PermErrorResult, result)
+	}
+
+	if err != spf.ErrTooManyVoidLookups {
+		t.Errorf("Error should be 'toomanyvoidlookups', got '%v' instead", err)
+	}
+}
+
+func TestMXHostLimit(t *testing.T) {
+	resolver := &spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":      {"v=spf1 mx -all"},
+			"many.voulter.com": {"v=spf1 mx -all"},
+		},
+		MX: map[string][]string{},
+		A:  map[string][]net.IP{},
+	}
+
+	for i := 0; i < spf.MaxMXHosts; i++ {
+		host := fmt.Sprintf("mx%d.voulter.com.", i)
+		resolver.MX["voulter.com"] = append(resolver.MX["voulter.com"], host)
+		resolver.A[host[:len(host)-1]] = []net.IP{net.ParseIP(fmt.Sprintf("192.0.2.%d", i+1))}
+	}
+
+	resolver.MX["many.voulter.com"] = append(resolver.MX["voulter.com"], "mx10.voulter.com.")
+	checker := spf.NewChecker(resolver)
+
+	// The last of 10 hosts is checked as well
+	result, _, err := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != spf.PassResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PassResult, result)
+	}
+
+	result, _, err = checker.CheckHost(context.Background(), net.ParseIP("192.0.2.1"), "many.voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult {
+		t.Errorf("False Result. Expected %q, got %q", spf.PermErrorResult, result)
+	}
+
+	if err != spf.ErrTooManyMXHosts {
+		t.Errorf("Error should be 'toomanymxhosts', got '%v' instead", err)
+	}
+}
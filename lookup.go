@@ -1,131 +1,448 @@
 package spf
 
 import (
+	"context"
 	"net"
+	"strings"
 
 	"github.com/miekg/dns"
 )
 
-// Get an SPF Record as string from a domain
+// A Resolver answers all dns queries which are needed to evaluate spf records
 //
-// Returns an error if no spf record is found or dns name couldn't be resolved
-func LookupSPF(domain string, nameserver string) (string, error) {
+// Implement this interface to use a caching resolver, the system resolver
+// or fixed records in tests. Lookups of names which don't exist (NXDOMAIN)
+// must return ErrNotFound
+type Resolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)  // Texts of the txt records of name, the strings of a record joined
+	LookupA(ctx context.Context, name string) ([]net.IP, error)    // Addresses of the a records of name
+	LookupAAAA(ctx context.Context, name string) ([]net.IP, error) // Addresses of the aaaa records of name
+	LookupMX(ctx context.Context, name string) ([]string, error)   // Exchange hosts of the mx records of name
+	LookupPTR(ctx context.Context, name string) ([]string, error)  // Host names of the ptr records of name
+}
+
+// Resolver which sends every query to a single nameserver
+type DNSResolver struct {
+	Nameserver string      // Address of the nameserver including the port, for example 8.8.8.8:53
+	Client     *dns.Client // Client used for the queries. A default client is used if nil
+}
+
+// Returns a resolver which queries nameserver
+func NewDNSResolver(nameserver string) *DNSResolver {
+	return &DNSResolver{Nameserver: nameserver, Client: new(dns.Client)}
+}
+
+func (r *DNSResolver) exchange(ctx context.Context, name string, qtype uint16) ([]dns.RR, error) {
+	c := r.Client
+
+	if c == nil {
+		c = new(dns.Client)
+	}
+
+	// EDNS0 lets the answers of large txt records fit into a single udp
+	// message, answers which are still too large are retried over tcp
 	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeTXT)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+	m.SetQuestion(dns.Fqdn(name), qtype)
+	m.SetEdns0(4096, false)
+	in, _, err := c.ExchangeContext(ctx, m, r.Nameserver)
+
+	if err == nil && in.Truncated && !strings.HasPrefix(c.Net, "tcp") {
+		in, _, err = tcpClient(c).ExchangeContext(ctx, m, r.Nameserver)
+	}
 
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	switch in.Rcode {
+	case dns.RcodeSuccess:
+		return in.Answer, nil
+	case dns.RcodeNameError:
+		return nil, ErrNotFound
+	default:
+		return nil, ErrServerFailure
+	}
+}
+
+// Returns a client with the settings of c which queries over tcp
+func tcpClient(c *dns.Client) *dns.Client {
+	return &dns.Client{
+		Net:          "tcp",
+		Dialer:       c.Dialer,
+		Timeout:      c.Timeout,
+		DialTimeout:  c.DialTimeout,
+		ReadTimeout:  c.ReadTimeout,
+		WriteTimeout: c.WriteTimeout,
+		TsigSecret:   c.TsigSecret,
+		TsigProvider: c.TsigProvider,
+	}
+}
+
+func (r *DNSResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	answers, err := r.exchange(ctx, name, dns.TypeTXT)
+
+	if err != nil {
+		return nil, err
 	}
 
-	for _, answer := range in.Answer {
+	var texts []string
+
+	for _, answer := range answers {
+		// A txt record longer than 255 bytes is split into several strings
 		if answer, ok := answer.(*dns.TXT); ok {
-			for _, record := range answer.Txt {
-				if IsSPF(record) {
-					return record, nil
-				}
-			}
+			texts = append(texts, strings.Join(answer.Txt, ""))
 		}
 	}
 
-	return "", ErrNotFound
+	return texts, nil
 }
 
-// Returns first a record as net.IP
-//
-// Returns an error if dns name couldn't be resolved
-func LookupARec(domain string, nameserver string) (net.IP, error) {
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+func (r *DNSResolver) LookupA(ctx context.Context, name string) ([]net.IP, error) {
+	answers, err := r.exchange(ctx, name, dns.TypeA)
 
 	if err != nil {
 		return nil, err
 	}
 
-	for _, answer := range in.Answer {
+	var ips []net.IP
+
+	for _, answer := range answers {
 		if answer, ok := answer.(*dns.A); ok {
-			return answer.A, nil
+			ips = append(ips, answer.A)
+		}
+	}
+
+	return ips, nil
+}
+
+func (r *DNSResolver) LookupAAAA(ctx context.Context, name string) ([]net.IP, error) {
+	answers, err := r.exchange(ctx, name, dns.TypeAAAA)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var ips []net.IP
+
+	for _, answer := range answers {
+		if answer, ok := answer.(*dns.AAAA); ok {
+			ips = append(ips, answer.AAAA)
+		}
+	}
+
+	return ips, nil
+}
+
+func (r *DNSResolver) LookupMX(ctx context.Context, name string) ([]string, error) {
+	answers, err := r.exchange(ctx, name, dns.TypeMX)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+
+	for _, answer := range answers {
+		if answer, ok := answer.(*dns.MX); ok {
+			hosts = append(hosts, answer.Mx)
 		}
 	}
 
-	return nil, nil
+	return hosts, nil
 }
 
-// Checks if ip is contained in a record
+func (r *DNSResolver) LookupPTR(ctx context.Context, name string) ([]string, error) {
+	answers, err := r.exchange(ctx, name, dns.TypePTR)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+
+	for _, answer := range answers {
+		if answer, ok := answer.(*dns.PTR); ok {
+			hosts = append(hosts, answer.Ptr)
+		}
+	}
+
+	return hosts, nil
+}
+
+// Get an SPF Record as string from a domain
 //
-// Returns an error if dns name couldn't be resolved
-func MatchIPWithARec(ip net.IP, domain string, nameserver string) (bool, error) {
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeA)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+// Returns an error if no spf record is found or dns name couldn't be resolved.
+// Returns ErrMultipleRecords if the domain has more than one spf record
+func LookupSPF(domain string, resolver Resolver) (string, error) {
+	return LookupSPFContext(context.Background(), domain, resolver)
+}
+
+// Same as LookupSPF, but the query is aborted once ctx is done
+func LookupSPFContext(ctx context.Context, domain string, resolver Resolver) (string, error) {
+	return lookupSPF(ctx, domain, resolver)
+}
+
+func lookupSPF(ctx context.Context, domain string, resolver Resolver) (string, error) {
+	records, err := resolver.LookupTXT(ctx, domain)
 
 	if err != nil {
-		return false, err
+		return "", err
 	}
 
-	for _, answer := range in.Answer {
-		if answer, ok := answer.(*dns.A); ok {
-			if ip.Equal(answer.A) {
-				return true, nil
-			}
+	spf := ""
+	found := false
+
+	for _, record := range records {
+		if !IsSPF(record) {
+			continue
+		}
+
+		if found {
+			return "", ErrMultipleRecords
 		}
+
+		spf = record
+		found = true
 	}
 
-	return false, nil
+	if !found {
+		return "", ErrNotFound
+	}
+
+	return spf, nil
+}
+
+// Returns first a record as net.IP
+//
+// Returns an error if dns name couldn't be resolved
+func LookupARec(domain string, resolver Resolver) (net.IP, error) {
+	return LookupARecContext(context.Background(), domain, resolver)
+}
+
+// Same as LookupARec, but the query is aborted once ctx is done
+func LookupARecContext(ctx context.Context, domain string, resolver Resolver) (net.IP, error) {
+	ips, err := resolver.LookupA(ctx, domain)
+
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+
+	if len(ips) == 0 {
+		return nil, nil
+	}
+
+	return ips[0], nil
+}
+
+// Checks if ip is contained in a record, or in aaaa record for ipv6 addresses
+//
+// Returns an error if dns name couldn't be resolved
+func MatchIPWithARec(ip net.IP, domain string, resolver Resolver) (bool, error) {
+	return MatchIPWithARecContext(context.Background(), ip, domain, resolver)
+}
+
+// Same as MatchIPWithARec, but the queries are aborted once ctx is done
+func MatchIPWithARecContext(ctx context.Context, ip net.IP, domain string, resolver Resolver) (bool, error) {
+	eval := &Evaluation{Resolver: resolver, IP: ip}
+	return eval.matchA(ctx, domain, 32, 128)
 }
 
 // Checks if ip is found in a record which was referenced by mx record
 //
 // Returns an error if dns name couldn't be resolved
-func MatchIPWithMXRec(ip net.IP, domain string, nameserver string) (bool, error) {
-	m := new(dns.Msg)
-	m.SetQuestion(dns.Fqdn(domain), dns.TypeMX)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+func MatchIPWithMXRec(ip net.IP, domain string, resolver Resolver) (bool, error) {
+	return MatchIPWithMXRecContext(context.Background(), ip, domain, resolver)
+}
+
+// Same as MatchIPWithMXRec, but the queries are aborted once ctx is done
+func MatchIPWithMXRecContext(ctx context.Context, ip net.IP, domain string, resolver Resolver) (bool, error) {
+	eval := &Evaluation{Resolver: resolver, IP: ip}
+	return eval.matchMX(ctx, domain, 32, 128)
+}
+
+// Checks if ip resolves to domain name of variable domain or one of its subdomains
+//
+// Returns an error if dns name couldn't be resolved
+func MatchIPWithPtrRec(ip net.IP, domain string, resolver Resolver) (bool, error) {
+	return MatchIPWithPtrRecContext(context.Background(), ip, domain, resolver)
+}
+
+// Same as MatchIPWithPtrRec, but the queries are aborted once ctx is done
+func MatchIPWithPtrRecContext(ctx context.Context, ip net.IP, domain string, resolver Resolver) (bool, error) {
+	eval := &Evaluation{Resolver: resolver, IP: ip}
+	return eval.matchPTR(ctx, domain)
+}
+
+// Checks if the client ip is in one of the networks formed by the a records
+// of domain and the prefix lengths. The aaaa records are used for ipv6 clients
+func (eval *Evaluation) matchA(ctx context.Context, domain string, ip4CIDR int, ip6CIDR int) (bool, error) {
+	ips, err := lookupIP(ctx, eval.Resolver, domain, eval.IP)
 
 	if err != nil {
 		return false, err
 	}
 
-	for _, answer := range in.Answer {
-		if answer, ok := answer.(*dns.MX); ok {
-			match, err := MatchIPWithARec(ip, answer.Mx, nameserver)
+	if err := eval.Budget.void(len(ips)); err != nil {
+		return false, err
+	}
 
-			if err != nil {
-				return false, err
-			}
+	return containsIP(ips, eval.IP, ip4CIDR, ip6CIDR), nil
+}
+
+// Checks if the client ip is in one of the networks formed by the addresses
+// of the mx hosts of domain and the prefix lengths
+//
+// The addresses of every host are looked up. Returns ErrTooManyMXHosts if
+// domain has more than MaxMXHosts of them (RFC 7208 section 4.6.4)
+func (eval *Evaluation) matchMX(ctx context.Context, domain string, ip4CIDR int, ip6CIDR int) (bool, error) {
+	hosts, err := eval.Resolver.LookupMX(ctx, domain)
+
+	if err != nil && err != ErrNotFound {
+		return false, err
+	}
 
-			return match, nil
+	if err := eval.Budget.void(len(hosts)); err != nil {
+		return false, err
+	}
+
+	if len(hosts) > MaxMXHosts {
+		return false, ErrTooManyMXHosts
+	}
+
+	for _, host := range hosts {
+		ips, err := lookupIP(ctx, eval.Resolver, host, eval.IP)
+
+		if err != nil {
+			return false, err
+		}
+
+		if containsIP(ips, eval.IP, ip4CIDR, ip6CIDR) {
+			return true, nil
 		}
 	}
 
 	return false, nil
 }
 
-// Checks if ip resolves to domain name of variable domain
-//
-// Returns an error if dns name couldn't be resolved
-func MatchIPWithPtrRec(ip net.IP, domain string, nameserver string) (bool, error) {
-	m := new(dns.Msg)
-	m.SetQuestion(ip.String(), dns.TypePTR)
-	c := new(dns.Client)
-	in, _, err := c.Exchange(m, nameserver)
+// Checks if a validated host name of the client ip is domain or one of its
+// subdomains (RFC 7208 section 5.5)
+func (eval *Evaluation) matchPTR(ctx context.Context, domain string) (bool, error) {
+	names, err := eval.Resolver.LookupPTR(ctx, reverseName(eval.IP))
 
-	if err != nil {
+	if err != nil && err != ErrNotFound {
+		// A failed ptr lookup is no match instead of an error, unless the
+		// evaluation was cancelled or timed out
+		return false, ctx.Err()
+	}
+
+	if err := eval.Budget.void(len(names)); err != nil {
 		return false, err
 	}
 
-	for _, answer := range in.Answer {
-		if answer, ok := answer.(*dns.PTR); ok {
-			if answer.Ptr == domain {
-				return true, nil
-			}
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	for _, name := range eval.validateNames(ctx, names) {
+		if name == domain || strings.HasSuffix(name, "."+domain) {
+			return true, nil
 		}
 	}
 
 	return false, nil
 }
+
+// Returns the names which resolve back to the client ip. Only the first
+// 10 names are checked, names whose lookup fails are skipped
+func (eval *Evaluation) validateNames(ctx context.Context, names []string) []string {
+	var validated []string
+
+	if len(names) > 10 {
+		names = names[:10]
+	}
+
+	for _, name := range names {
+		ips, err := lookupIP(ctx, eval.Resolver, name, eval.IP)
+
+		if err != nil {
+			continue
+		}
+
+		for _, ip := range ips {
+			if ip.Equal(eval.IP) {
+				validated = append(validated, strings.ToLower(strings.TrimSuffix(name, ".")))
+				break
+			}
+		}
+	}
+
+	return validated
+}
+
+// Checks if domain has an a record. RFC 7208 section 5.7 requires an a
+// lookup even if the client connected with ipv6
+func (eval *Evaluation) exists(ctx context.Context, domain string) (bool, error) {
+	ips, err := eval.Resolver.LookupA(ctx, domain)
+
+	if err != nil && err != ErrNotFound {
+		return false, err
+	}
+
+	if err := eval.Budget.void(len(ips)); err != nil {
+		return false, err
+	}
+
+	return len(ips) > 0, nil
+}
+
+// Checks if ip is in one of the networks formed by ips and the prefix lengths
+// of their address family
+func containsIP(ips []net.IP, ip net.IP, ip4CIDR int, ip6CIDR int) bool {
+	for _, address := range ips {
+		var mask net.IPMask
+
+		if address.To4() != nil && ip.To4() != nil {
+			mask = net.CIDRMask(ip4CIDR, 32)
+			address, ip = address.To4(), ip.To4()
+		} else if address.To4() == nil && ip.To4() == nil {
+			mask = net.CIDRMask(ip6CIDR, 128)
+		} else {
+			continue
+		}
+
+		if address.Mask(mask).Equal(ip.Mask(mask)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the addresses of name with the same family as ip. This is an
+// aaaa lookup for ipv6 addresses and an a lookup for ipv4 addresses
+func lookupIP(ctx context.Context, resolver Resolver, name string, ip net.IP) ([]net.IP, error) {
+	var ips []net.IP
+	var err error
+
+	if ip.To4() != nil {
+		ips, err = resolver.LookupA(ctx, name)
+	} else {
+		ips, err = resolver.LookupAAAA(ctx, name)
+	}
+
+	if err == ErrNotFound {
+		return nil, nil
+	}
+
+	return ips, err
+}
+
+// Returns the name of the ptr records of ip, for example 4.3.2.1.in-addr.arpa
+// for 1.2.3.4 and the reversed nibbles followed by ip6.arpa for ipv6 addresses
+func reverseName(ip net.IP) string {
+	name, err := dns.ReverseAddr(ip.String())
+
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSuffix(name, ".")
+}
@@ -1,13 +1,18 @@
 package spf_test
 
 import (
+	"context"
+	"net"
+	"strings"
 	"testing"
 
+	"github.com/miekg/dns"
 	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
 )
 
 func TestLookupSPF(t *testing.T) {
-	record, err := spf.LookupSPF("gmail.com", "8.8.8.8:53")
+	record, err := spf.LookupSPF("gmail.com", snapshotZone)
 	if err != nil {
 		t.Errorf("No record found: %s", err)
 	}
@@ -16,3 +21,167 @@ func TestLookupSPF(t *testing.T) {
 		t.Errorf("Value is not spf record")
 	}
 }
+
+func TestLookupSPFResolver(t *testing.T) {
+	resolver := &spftest.Zone{TXT: map[string][]string{
+		"voulter.com": {"google-site-verification=abc", "v=spf1 a -all"},
+	}}
+
+	record, err := spf.LookupSPF("voulter.com", resolver)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if record != "v=spf1 a -all" {
+		t.Errorf("Expected 'v=spf1 a -all', got '%s' instead", record)
+	}
+
+	_, err = spf.LookupSPF("example.com", resolver)
+
+	if err != spf.ErrNotFound {
+		t.Errorf("Error should be 'notfound', got '%v' instead", err)
+	}
+}
+
+func TestMatchIPWithMXRecResolver(t *testing.T) {
+	resolver := &spftest.Zone{
+		MX: map[string][]string{"voulter.com": {"mail.voulter.com."}},
+		A:  map[string][]net.IP{"mail.voulter.com": {net.ParseIP("192.0.2.10")}},
+	}
+
+	match, err := spf.MatchIPWithMXRec(net.ParseIP("192.0.2.10"), "voulter.com", resolver)
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !match {
+		t.Errorf("192.0.2.10 should match the mx of voulter.com")
+	}
+}
+
+func TestLookupSPFMultipleRecords(t *testing.T) {
+	resolver := &spftest.Zone{TXT: map[string][]string{
+		"voulter.com":  {"v=spf1 a -all", "v=spf1 mx -all"},
+		"voulter.net":  {"v=spf10 a -all", "v=spf1 mx -all"},
+		"voulter.org":  {"v=spf1", "spf1 a"},
+		"voulter.info": {"v=spf1a -all"},
+	}}
+
+	_, err := spf.LookupSPF("voulter.com", resolver)
+
+	if err != spf.ErrMultipleRecords {
+		t.Errorf("Error should be 'multiplerecords', got '%v' instead", err)
+	}
+
+	record, err := spf.LookupSPF("voulter.net", resolver)
+
+	if err != nil || record != "v=spf1 mx -all" {
+		t.Errorf("Expected 'v=spf1 mx -all', got '%s' (%v) instead", record, err)
+	}
+
+	record, err = spf.LookupSPF("voulter.org", resolver)
+
+	if err != nil || record != "v=spf1" {
+		t.Errorf("Expected 'v=spf1', got '%s' (%v) instead", record, err)
+	}
+
+	_, err = spf.LookupSPF("voulter.info", resolver)
+
+	if err != spf.ErrNotFound {
+		t.Errorf("Error should be 'notfound', got '%v' instead", err)
+	}
+
+	result, _, err := spf.NewChecker(resolver).CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result != spf.PermErrorResult || err != spf.ErrMultipleRecords {
+		t.Errorf("False Result. Expected %q, got %q (%v)", spf.PermErrorResult, result, err)
+	}
+}
+
+func TestDNSResolverLookupTXT(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+		m.Answer = append(m.Answer,
+			&dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"v=spf1 ip4:192.0.2.0/24 ", "-all"}},
+			&dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{"google-site-verification=abc"}},
+		)
+		w.WriteMsg(m)
+	})}
+
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	texts, err := spf.NewDNSResolver(conn.LocalAddr().String()).LookupTXT(context.Background(), "voulter.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(texts) != 2 || texts[0] != "v=spf1 ip4:192.0.2.0/24 -all" {
+		t.Errorf("Expected the strings of a record to be joined, got %q instead", texts)
+	}
+}
+
+func TestDNSResolverTruncated(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Skip(err)
+	}
+
+	conn, err := net.ListenPacket("udp", listener.Addr().String())
+
+	if err != nil {
+		listener.Close()
+		t.Skip(err)
+	}
+
+	edns := make(chan bool, 1)
+	record := "v=spf1 " + strings.Repeat("ip4:192.0.2.1 ", 30) + "-all"
+
+	handler := dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		m := new(dns.Msg)
+		m.SetReply(r)
+
+		// Only the answer over tcp is complete
+		if _, udp := w.RemoteAddr().(*net.UDPAddr); udp {
+			edns <- r.IsEdns0() != nil
+			m.Truncated = true
+		} else {
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET}, Txt: []string{record[:255], record[255:]}})
+		}
+
+		w.WriteMsg(m)
+	})
+
+	udpServer := &dns.Server{PacketConn: conn, Handler: handler}
+	tcpServer := &dns.Server{Listener: listener, Handler: handler}
+
+	go udpServer.ActivateAndServe()
+	go tcpServer.ActivateAndServe()
+	defer udpServer.Shutdown()
+	defer tcpServer.Shutdown()
+
+	texts, err := spf.NewDNSResolver(conn.LocalAddr().String()).LookupTXT(context.Background(), "voulter.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(texts) != 1 || texts[0] != record {
+		t.Errorf("Expected the record of the tcp answer, got %q instead", texts)
+	}
+
+	if !<-edns {
+		t.Errorf("Query over udp should announce a larger message size with EDNS0")
+	}
+}
@@ -0,0 +1,290 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Characters which may split the value of a macro into parts
+const macroDelimiters = ".-+,/_="
+
+// Expands all macros of a domain-spec as described in RFC 7208 section 7
+//
+// If the expanded domain name is longer than 253 characters, labels are
+// removed from the left until it fits
+func ExpandDomainSpec(ctx context.Context, eval *Evaluation, spec string) (string, error) {
+	domain, err := ExpandMacros(ctx, eval, spec)
+
+	if err != nil {
+		return "", err
+	}
+
+	for len(domain) > 253 {
+		dot := strings.IndexByte(domain, '.')
+
+		if dot < 0 {
+			break
+		}
+
+		domain = domain[dot+1:]
+	}
+
+	return domain, nil
+}
+
+// Expands all macros of a macro-string as described in RFC 7208 section 7
+//
+// Returns ErrInvalidMacro if the string contains a malformed macro
+func ExpandMacros(ctx context.Context, eval *Evaluation, macroString string) (string, error) {
+	return expandMacros(ctx, eval, macroString, false)
+}
+
+// Expands all macros of an explanation string. In addition to the macros of
+// domain-specs it can contain the c, r and t macros
+func ExpandExplanation(ctx context.Context, eval *Evaluation, explanation string) (string, error) {
+	return expandMacros(ctx, eval, explanation, true)
+}
+
+// Checks if text is an explanation-string of RFC 7208 section 6.2, which
+// consists of macro-strings and spaces only
+func isExplanationString(text string) bool {
+	for _, part := range strings.Split(text, " ") {
+		if invalidMacroString(part, macroLetters) >= 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+func expandMacros(ctx context.Context, eval *Evaluation, macroString string, exp bool) (string, error) {
+	if !strings.Contains(macroString, "%") {
+		return macroString, nil
+	}
+
+	var expanded strings.Builder
+
+	for i := 0; i < len(macroString); i++ {
+		if macroString[i] != '%' {
+			expanded.WriteByte(macroString[i])
+			continue
+		}
+
+		if i+1 == len(macroString) {
+			return "", ErrInvalidMacro
+		}
+
+		i++
+
+		switch macroString[i] {
+		case '%':
+			expanded.WriteByte('%')
+		case '_':
+			expanded.WriteByte(' ')
+		case '-':
+			expanded.WriteString("%20")
+		case '{':
+			end := strings.IndexByte(macroString[i:], '}')
+
+			if end < 0 {
+				return "", ErrInvalidMacro
+			}
+
+			value, err := expandMacro(ctx, eval, macroString[i+1:i+end], exp)
+
+			if err != nil {
+				return "", err
+			}
+
+			expanded.WriteString(value)
+			i += end
+		default:
+			return "", ErrInvalidMacro
+		}
+	}
+
+	return expanded.String(), nil
+}
+
+// Expands the content between "%{" and "}" of a single macro
+func expandMacro(ctx context.Context, eval *Evaluation, macro string, exp bool) (string, error) {
+	if macro == "" {
+		return "", ErrInvalidMacro
+	}
+
+	letter := macro[0]
+	value, err := macroValue(ctx, eval, letter|0x20, exp)
+
+	if err != nil {
+		return "", err
+	}
+
+	rest := macro[1:]
+	digits := 0
+	count := 0
+
+	for digits < len(rest) && rest[digits] >= '0' && rest[digits] <= '9' {
+		if count < 1000 {
+			count = count*10 + int(rest[digits]-'0')
+		}
+
+		digits++
+	}
+
+	if digits > 0 && count == 0 {
+		return "", ErrInvalidMacro
+	}
+
+	rest = rest[digits:]
+	reverse := false
+
+	if rest != "" && (rest[0] == 'r' || rest[0] == 'R') {
+		reverse = true
+		rest = rest[1:]
+	}
+
+	delimiters := rest
+
+	for _, delimiter := range delimiters {
+		if !strings.ContainsRune(macroDelimiters, delimiter) {
+			return "", ErrInvalidMacro
+		}
+	}
+
+	if delimiters == "" {
+		delimiters = "."
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiters, r)
+	})
+
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	if count > 0 && count < len(parts) {
+		parts = parts[len(parts)-count:]
+	}
+
+	value = strings.Join(parts, ".")
+
+	if letter >= 'A' && letter <= 'Z' {
+		value = urlEscape(value)
+	}
+
+	return value, nil
+}
+
+// Returns the value of a lowercase macro letter. The c, r and t macros are only valid in explanations
+func macroValue(ctx context.Context, eval *Evaluation, letter byte, exp bool) (string, error) {
+	switch letter {
+	case 's':
+		return eval.Sender, nil
+	case 'l':
+		if at := strings.LastIndexByte(eval.Sender, '@'); at > 0 {
+			return eval.Sender[:at], nil
+		}
+
+		return "postmaster", nil
+	case 'o':
+		return eval.Sender[strings.LastIndexByte(eval.Sender, '@')+1:], nil
+	case 'd':
+		return eval.Domain, nil
+	case 'i':
+		return dottedIP(eval.IP), nil
+	case 'p':
+		return validatedName(ctx, eval), nil
+	case 'v':
+		if eval.IP.To4() != nil {
+			return "in-addr", nil
+		}
+
+		return "ip6", nil
+	case 'h':
+		return eval.Helo, nil
+	case 'c':
+		if exp {
+			return eval.IP.String(), nil
+		}
+	case 'r':
+		if exp && eval.Receiver == "" {
+			return "unknown", nil
+		}
+
+		if exp {
+			return eval.Receiver, nil
+		}
+	case 't':
+		if exp {
+			return strconv.FormatInt(time.Now().Unix(), 10), nil
+		}
+	}
+
+	return "", ErrInvalidMacro
+}
+
+// Returns an ipv4 address in dotted quad notation and an ipv6 address as
+// dot separated nibbles, like the i macro requires
+func dottedIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	nibbles := make([]string, 0, 32)
+
+	for _, b := range ip.To16() {
+		nibbles = append(nibbles, fmt.Sprintf("%x", b>>4), fmt.Sprintf("%x", b&0xf))
+	}
+
+	return strings.Join(nibbles, ".")
+}
+
+// Returns a validated domain name of the ip for the p macro or "unknown" if there is none
+//
+// The current domain and its subdomains are preferred
+func validatedName(ctx context.Context, eval *Evaluation) string {
+	names, err := eval.Resolver.LookupPTR(ctx, reverseName(eval.IP))
+
+	if err != nil {
+		return "unknown"
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(eval.Domain, "."))
+	validated := "unknown"
+
+	for _, name := range eval.validateNames(ctx, names) {
+		if name == domain {
+			return name
+		}
+
+		if validated == "unknown" || strings.HasSuffix(name, "."+domain) {
+			validated = name
+		}
+	}
+
+	return validated
+}
+
+// Escapes all characters except the unreserved ones of RFC 3986
+func urlEscape(value string) string {
+	var escaped strings.Builder
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-._~", c) >= 0 {
+			escaped.WriteByte(c)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", c)
+		}
+	}
+
+	return escaped.String()
+}
@@ -0,0 +1,174 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+)
+
+func TestExpandMacrosRFCExamples(t *testing.T) {
+	// Examples of RFC 7208 section 7.4
+	eval := &spf.Evaluation{
+		IP:     net.ParseIP("192.0.2.3"),
+		Domain: "email.example.com",
+		Sender: "strong-bad@email.example.com",
+	}
+
+	expected := map[string]string{
+		"%{s}":                              "strong-bad@email.example.com",
+		"%{o}":                              "email.example.com",
+		"%{d}":                              "email.example.com",
+		"%{d4}":                             "email.example.com",
+		"%{d3}":                             "email.example.com",
+		"%{d2}":                             "example.com",
+		"%{d1}":                             "com",
+		"%{dr}":                             "com.example.email",
+		"%{d2r}":                            "example.email",
+		"%{l}":                              "strong-bad",
+		"%{l-}":                             "strong.bad",
+		"%{lr}":                             "strong-bad",
+		"%{lr-}":                            "bad.strong",
+		"%{l1r-}":                           "strong",
+		"%{ir}.%{v}._spf.%{d2}":             "3.2.0.192.in-addr._spf.example.com",
+		"%{lr-}.lp._spf.%{d2}":              "bad.strong.lp._spf.example.com",
+		"%{lr-}.lp.%{ir}.%{v}._spf.%{d2}":   "bad.strong.lp.3.2.0.192.in-addr._spf.example.com",
+		"%{ir}.%{v}.%{l1r-}.lp._spf.%{d2}":  "3.2.0.192.in-addr.strong.lp._spf.example.com",
+		"%{d2}.trusted-domains.example.net": "example.com.trusted-domains.example.net",
+		"%%%_%-":                            "% %20",
+		"%{S}":                              "strong-bad%40email.example.com",
+	}
+
+	for macroString, value := range expected {
+		result, err := spf.ExpandMacros(context.Background(), eval, macroString)
+
+		if err != nil {
+			t.Errorf("%s: %s", macroString, err)
+			continue
+		}
+
+		if result != value {
+			t.Errorf("Not as expected: %s expands to '%s', expected '%s'", macroString, result, value)
+		}
+	}
+}
+
+func TestExpandMacrosIPv6(t *testing.T) {
+	eval := &spf.Evaluation{
+		IP:     net.ParseIP("2001:db8::cb01"),
+		Domain: "email.example.com",
+		Sender: "strong-bad@email.example.com",
+	}
+
+	result, err := spf.ExpandMacros(context.Background(), eval, "%{ir}.%{v}._spf.%{d2}")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := "1.0.b.c.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.ip6._spf.example.com"
+
+	if result != expected {
+		t.Errorf("Not as expected: '%s' does not equal to '%s'", result, expected)
+	}
+}
+
+func TestExpandMacrosInvalid(t *testing.T) {
+	eval := &spf.Evaluation{
+		IP:     net.ParseIP("192.0.2.3"),
+		Domain: "email.example.com",
+		Sender: "strong-bad@email.example.com",
+	}
+
+	for _, macroString := range []string{"%", "%a", "%{d", "%{}", "%{x}", "%{d0}", "%{d2!}"} {
+		_, err := spf.ExpandMacros(context.Background(), eval, macroString)
+
+		if err != spf.ErrInvalidMacro {
+			t.Errorf("%s: Error should be 'invalidmacro', got '%v' instead", macroString, err)
+		}
+	}
+}
+
+func TestExpandDomainSpecTruncate(t *testing.T) {
+	eval := &spf.Evaluation{
+		IP:     net.ParseIP("192.0.2.3"),
+		Domain: strings.Repeat("abcdefghi.", 20) + "example.com",
+		Sender: "strong-bad@email.example.com",
+	}
+
+	result, err := spf.ExpandDomainSpec(context.Background(), eval, "%{d}.%{d}")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if len(result) > 253 || !strings.HasSuffix(result, ".example.com") {
+		t.Errorf("'%s' was not truncated from the left", result)
+	}
+}
+
+func TestExpandExplanation(t *testing.T) {
+	eval := &spf.Evaluation{
+		IP:       net.ParseIP("192.0.2.3"),
+		Domain:   "email.example.com",
+		Sender:   "strong-bad@email.example.com",
+		Receiver: "mx.example.org",
+	}
+
+	result, err := spf.ExpandExplanation(context.Background(), eval, "%{c} %{r} %{t}")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	parts := strings.Split(result, " ")
+
+	if len(parts) != 3 || parts[0] != "192.0.2.3" || parts[1] != "mx.example.org" || strings.Trim(parts[2], "0123456789") != "" {
+		t.Errorf("Not as expected: '%s'", result)
+	}
+
+	for _, macroString := range []string{"%{c}", "%{r}", "%{t}"} {
+		_, err := spf.ExpandMacros(context.Background(), eval, macroString)
+
+		if err != spf.ErrInvalidMacro {
+			t.Errorf("%s is only valid in explanations, got '%v' instead of 'invalidmacro'", macroString, err)
+		}
+	}
+}
+
+func TestExpandMacrosValidatedName(t *testing.T) {
+	eval := &spf.Evaluation{
+		Resolver: &spftest.Zone{
+			PTR: map[string][]string{
+				"3.2.0.192.in-addr.arpa": {"other.example.net.", "mx.email.example.com.", "fake.email.example.com."},
+			},
+			A: map[string][]net.IP{
+				"other.example.net":    {net.ParseIP("192.0.2.3")},
+				"mx.email.example.com": {net.ParseIP("192.0.2.3")},
+			},
+		},
+		IP:     net.ParseIP("192.0.2.3"),
+		Domain: "email.example.com",
+		Sender: "strong-bad@email.example.com",
+	}
+
+	result, err := spf.ExpandMacros(context.Background(), eval, "%{p}")
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if result != "mx.email.example.com" {
+		t.Errorf("Not as expected: '%s' does not equal to 'mx.email.example.com'", result)
+	}
+
+	eval.IP = net.ParseIP("192.0.2.4")
+	result, _ = spf.ExpandMacros(context.Background(), eval, "%{p}")
+
+	if result != "unknown" {
+		t.Errorf("Not as expected: '%s' does not equal to 'unknown'", result)
+	}
+}
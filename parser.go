@@ -1,7 +1,9 @@
 package spf
 
 import (
-	"errors"
+	"bytes"
+	"encoding/json"
+	"strconv"
 	"strings"
 )
 
@@ -10,34 +12,66 @@ type Qualifier int
 // A Qualifier can be
 // -, +, ~, ?
 const (
-	PassQualifier     = iota // +
-	FailQualifier            // -
-	SoftFailQualifier        // ~
-	NeutralQualifier         // ?
-	NoneQualifier            // No Mechanism matched the current ip
+	PassQualifier     Qualifier = iota // +
+	FailQualifier                      // -
+	SoftFailQualifier                  // ~
+	NeutralQualifier                   // ?
 )
 
+// Kind of a mechanism or modifier
+type MechanismKind int
+
 // Caution! Modifiers are also called mechanisms in this library,
 // but they get distinguished correctly
 const (
-	AllMechanism      = iota + 1 // all
-	IPv4Mechanism                // ip4
-	IPv6Mechanism                // ip6
-	AMechanism                   // a
-	MXMechanism                  // mx
-	PTRMechanism                 // ptr
-	ExistsMechanism              // exists
-	IncludeMechanism             // include
-	RedirectMechanism            // redirect
+	AllMechanism      MechanismKind = iota + 1 // all
+	IPv4Mechanism                              // ip4
+	IPv6Mechanism                              // ip6
+	AMechanism                                 // a
+	MXMechanism                                // mx
+	PTRMechanism                               // ptr
+	ExistsMechanism                            // exists
+	IncludeMechanism                           // include
+	RedirectMechanism                          // redirect
+	ExpMechanism                               // exp
+	ModifierMechanism                          // Any other modifier, its name is stored in Name
 )
 
 // An Argument in the SPF Record
 // They are read from left to right (Left is the most powerful one)
 // Qualifiers determine how the ip should be treated if the mechanism matches the address
+//
+// The a and mx mechanisms store their domain-spec in Value and the prefix
+// lengths to compare the addresses with in IP4CIDR and IP6CIDR.
+// A nil length wasn't given and means 32 or 128, see CIDRLengths
 type Mechanism struct {
 	Qualifier Qualifier
-	Mechanism int
+	Mechanism MechanismKind
 	Value     string
+	Name      string // Name of an unknown modifier
+	IP4CIDR   *int   // ip4-cidr-length of a and mx
+	IP6CIDR   *int   // ip6-cidr-length of a and mx
+}
+
+// Returns a pointer to length to set IP4CIDR or IP6CIDR of a mechanism
+func CIDRLength(length int) *int {
+	return &length
+}
+
+// Returns the ip4 and ip6 prefix lengths of a and mx. Lengths which
+// weren't given are 32 and 128
+func (m Mechanism) CIDRLengths() (int, int) {
+	ip4CIDR, ip6CIDR := 32, 128
+
+	if m.IP4CIDR != nil {
+		ip4CIDR = *m.IP4CIDR
+	}
+
+	if m.IP6CIDR != nil {
+		ip6CIDR = *m.IP6CIDR
+	}
+
+	return ip4CIDR, ip6CIDR
 }
 
 type MechanismParseContext struct {
@@ -52,128 +86,251 @@ type MechanismParseContext struct {
 // This Type is sorted from the most to the least powerful mechanism.
 type Record []Mechanism
 
-type Result string
-
 // Simple and fast check to validate SPF Record
+//
+// The text has to start with the version "v=spf1" followed by a space or
+// the end of the text, so "v=spf10" is no spf record (RFC 7208 section 4.5)
 func IsSPF(spf string) bool {
-	return strings.HasPrefix(spf, "v=spf1")
+	if len(spf) < len("v=spf1") || !strings.EqualFold(spf[:len("v=spf1")], "v=spf1") {
+		return false
+	}
+
+	return len(spf) == len("v=spf1") || spf[len("v=spf1")] == ' '
 }
 
 // Informationful way to read out spf record
+//
+// Use ParseTree to get the position of a syntax error
 func ParseSPF(spf string) (Record, error) {
-	var spfRecord = []Mechanism{}
-	var spfModifiers = []Mechanism{} // Reversive, just gets appended after record
-
-	if !IsSPF(spf) {
-		return nil, errors.New("nospf")
-	}
-
-	spfContent := spf[len("v=spf "):]
-
-	parseContext := MechanismParseContext{
-		Qualifier:         PassQualifier,
-		Mechanism:         "",
-		Value:             "",
-		WritingDescriptor: true,
-		Modifier:          false,
+	tree, err := ParseTree(spf)
+
+	if err != nil {
+		return nil, err.(*ParseError).Err
+	}
+
+	return tree.Record(), nil
+}
+
+// Names of the mechanisms and modifiers as they appear in records
+var mechanismNames = map[MechanismKind]string{
+	AllMechanism:      "all",
+	IPv4Mechanism:     "ip4",
+	IPv6Mechanism:     "ip6",
+	AMechanism:        "a",
+	MXMechanism:       "mx",
+	PTRMechanism:      "ptr",
+	ExistsMechanism:   "exists",
+	IncludeMechanism:  "include",
+	RedirectMechanism: "redirect",
+	ExpMechanism:      "exp",
+	ModifierMechanism: "modifier",
+}
+
+// Returns the name of the kind as it appears in records, like "include".
+// Unknown modifiers are "modifier"
+func (k MechanismKind) String() string {
+	if name, ok := mechanismNames[k]; ok {
+		return name
 	}
 
-	writeValue := &parseContext.Mechanism
+	return "MechanismKind(" + strconv.Itoa(int(k)) + ")"
+}
 
-	for _, chr := range spfContent {
-		switch chr {
-		case '+', '-', '~', '?':
-			if parseContext.Mechanism == "" && parseContext.Value == "" {
-				qualifier, err := EvaluateQualifier(chr)
+// Returns the character of the qualifier, like "-" for FailQualifier
+func (q Qualifier) String() string {
+	if q >= PassQualifier && q <= NeutralQualifier {
+		return string("+-~?"[q])
+	}
 
-				if err != nil {
-					return nil, err
-				}
+	return "Qualifier(" + strconv.Itoa(int(q)) + ")"
+}
 
-				parseContext.Qualifier = qualifier
-				continue
-			}
+// Returns the record as text which ParseSPF reads back into the same record.
+// Modifiers have to follow the mechanisms like in records returned by ParseSPF
+func (r Record) String() string {
+	var text strings.Builder
+	text.WriteString("v=spf1")
 
-			*writeValue += string(chr)
-		case ':':
-			if parseContext.Mechanism == "" {
-				return nil, ErrSyntax
-			}
+	for _, mechanism := range r {
+		text.WriteByte(' ')
+		text.WriteString(mechanism.String())
+	}
 
-			if parseContext.WritingDescriptor {
-				writeValue = &parseContext.Value
-				parseContext.WritingDescriptor = false
-			} else {
-				*writeValue += string(chr)
-			}
-		case '=':
-			if parseContext.Mechanism == "" {
-				return nil, ErrSyntax
-			}
+	return text.String()
+}
 
-			if parseContext.WritingDescriptor {
-				writeValue = &parseContext.Value
-				parseContext.WritingDescriptor = false
-				parseContext.Modifier = true
-			} else {
-				*writeValue += string(chr)
-			}
+// Returns the term like it appears in a record, for example "-ip4:192.0.2.0/24"
+// or "a:example.com/24//64". The pass qualifier and default prefix lengths are left out
+func (m Mechanism) String() string {
+	if m.Mechanism == ModifierMechanism {
+		return m.Name + "=" + m.Value
+	}
 
-		case ' ', '\n', '\r':
-			if parseContext.WritingDescriptor || parseContext.Value == "" {
-				continue
-			}
+	if m.IsModifier() {
+		return m.Mechanism.String() + "=" + m.Value
+	}
 
-			if parseContext.Modifier {
-				mechanism, err := EvaluateModifier(&parseContext)
+	var term strings.Builder
 
-				if err != nil {
-					return nil, err
-				}
+	if m.Qualifier != PassQualifier {
+		term.WriteString(m.Qualifier.String())
+	}
 
-				spfModifiers = append(spfModifiers, mechanism)
-			} else {
-				mechanism, err := EvaluateMechanism(&parseContext)
+	term.WriteString(m.Mechanism.String())
 
-				if err != nil {
-					return nil, err
-				}
+	if m.Value != "" {
+		term.WriteString(":" + m.Value)
+	}
 
-				spfRecord = append(spfRecord, mechanism)
-			}
+	if m.Mechanism == AMechanism || m.Mechanism == MXMechanism {
+		if m.IP4CIDR != nil {
+			term.WriteString("/" + strconv.Itoa(*m.IP4CIDR))
+		}
 
-			writeValue = &parseContext.Mechanism
-			parseContext.Qualifier = PassQualifier
-			parseContext.WritingDescriptor = true
-			parseContext.Mechanism = ""
-			parseContext.Value = ""
-			parseContext.Modifier = false
-		default:
-			*writeValue += string(chr)
+		if m.IP6CIDR != nil {
+			term.WriteString("//" + strconv.Itoa(*m.IP6CIDR))
 		}
 	}
 
-	if parseContext.Mechanism != "" {
-		if parseContext.Modifier {
-			mechanism, err := EvaluateModifier(&parseContext)
+	return term.String()
+}
+
+// Returns the record like String does
+func (r Record) MarshalText() ([]byte, error) {
+	return []byte(r.String()), nil
+}
+
+// Returns the record as JSON array of its mechanisms, see Mechanism.MarshalJSON
+func (r Record) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]Mechanism(r))
+}
+
+// Reads a record from a JSON array of mechanisms or from a JSON string with
+// the text of the record, which is parsed with ParseSPF
+func (r *Record) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte(`"`)) {
+		var text string
+
+		if err := json.Unmarshal(data, &text); err != nil {
+			return err
+		}
+
+		record, err := ParseSPF(text)
+
+		if err != nil {
+			return err
+		}
+
+		*r = record
+		return nil
+	}
+
+	var mechanisms []Mechanism
+
+	if err := json.Unmarshal(data, &mechanisms); err != nil {
+		return err
+	}
+
+	*r = mechanisms
+	return nil
+}
+
+// JSON object of a mechanism with readable qualifier and kind
+type mechanismJSON struct {
+	Qualifier string `json:"qualifier,omitempty"`
+	Kind      string `json:"kind"`
+	Name      string `json:"name,omitempty"`
+	Value     string `json:"value,omitempty"`
+	IP4CIDR   *int   `json:"ip4_cidr,omitempty"`
+	IP6CIDR   *int   `json:"ip6_cidr,omitempty"`
+}
+
+// Returns the term like String does
+func (m Mechanism) MarshalText() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// Returns the mechanism as JSON object, for example
+//
+//	{"qualifier":"-","kind":"a","value":"example.com","ip4_cidr":24}
+//
+// Modifiers have no qualifier and only a and mx have the prefix lengths
+// which were given
+func (m Mechanism) MarshalJSON() ([]byte, error) {
+	object := mechanismJSON{Kind: m.Mechanism.String(), Value: m.Value}
+
+	if !m.IsModifier() {
+		object.Qualifier = m.Qualifier.String()
+	}
+
+	if m.Mechanism == ModifierMechanism {
+		object.Name = m.Name
+	}
+
+	if m.Mechanism == AMechanism || m.Mechanism == MXMechanism {
+		object.IP4CIDR, object.IP6CIDR = m.IP4CIDR, m.IP6CIDR
+	}
+
+	return json.Marshal(object)
+}
+
+// Reads a mechanism written by MarshalJSON. A missing qualifier is pass and
+// missing prefix lengths of a and mx stay nil
+//
+// Returns ErrInvalidQualifier, ErrInvalidMechanism, ErrInvalidModifier or
+// ErrSyntax if a field has an invalid value
+func (m *Mechanism) UnmarshalJSON(data []byte) error {
+	var object mechanismJSON
+
+	if err := json.Unmarshal(data, &object); err != nil {
+		return err
+	}
 
-			if err != nil {
-				return nil, err
-			}
+	mechanism := Mechanism{Qualifier: PassQualifier, Value: object.Value, Name: object.Name}
 
-			spfModifiers = append(spfModifiers, mechanism)
-		} else {
-			mechanism, err := EvaluateMechanism(&parseContext)
+	if object.Qualifier != "" {
+		if len(object.Qualifier) != 1 {
+			return ErrInvalidQualifier
+		}
 
-			if err != nil {
-				return nil, err
-			}
+		qualifier, err := EvaluateQualifier(rune(object.Qualifier[0]))
 
-			spfRecord = append(spfRecord, mechanism)
+		if err != nil {
+			return err
 		}
+
+		mechanism.Qualifier = qualifier
 	}
 
-	return append(spfRecord, spfModifiers...), nil
+	for kind, name := range mechanismNames {
+		if strings.EqualFold(object.Kind, name) {
+			mechanism.Mechanism = kind
+		}
+	}
+
+	switch mechanism.Mechanism {
+	case 0:
+		return ErrInvalidMechanism
+	case ModifierMechanism:
+		if !isModifierName(object.Name) {
+			return ErrInvalidModifier
+		}
+	case AMechanism, MXMechanism:
+		mechanism.IP4CIDR, mechanism.IP6CIDR = object.IP4CIDR, object.IP6CIDR
+		ip4CIDR, ip6CIDR := mechanism.CIDRLengths()
+
+		if ip4CIDR < 0 || ip4CIDR > 32 || ip6CIDR < 0 || ip6CIDR > 128 {
+			return ErrSyntax
+		}
+	}
+
+	*m = mechanism
+	return nil
+}
+
+// Checks if the mechanism is a modifier (redirect, exp or an unknown one)
+func (m Mechanism) IsModifier() bool {
+	return m.Mechanism == RedirectMechanism || m.Mechanism == ExpMechanism || m.Mechanism == ModifierMechanism
 }
 
 func EvaluateQualifier(char rune) (Qualifier, error) {
@@ -193,7 +350,33 @@ func EvaluateQualifier(char rune) (Qualifier, error) {
 
 func EvaluateMechanism(context *MechanismParseContext) (Mechanism, error) {
 	mechanism := Mechanism{Qualifier: context.Qualifier, Value: context.Value}
-	switch strings.ToLower(context.Mechanism) {
+	name := context.Mechanism
+
+	// Prefix lengths directly after the name, like in "a/24"
+	if slash := strings.IndexByte(name, '/'); slash >= 0 {
+		if context.Value != "" {
+			return Mechanism{}, ErrSyntax
+		}
+
+		name, mechanism.Value = name[:slash], name[slash:]
+	}
+
+	switch strings.ToLower(name) {
+	case "a", "mx":
+		value, ip4CIDR, ip6CIDR, err := splitDualCIDR(mechanism.Value)
+
+		if err != nil {
+			return Mechanism{}, err
+		}
+
+		mechanism.Value, mechanism.IP4CIDR, mechanism.IP6CIDR = value, ip4CIDR, ip6CIDR
+	default:
+		if name != context.Mechanism {
+			return Mechanism{}, ErrInvalidMechanism
+		}
+	}
+
+	switch strings.ToLower(name) {
 	case "all":
 		mechanism.Mechanism = AllMechanism
 		return mechanism, nil
@@ -223,14 +406,78 @@ func EvaluateMechanism(context *MechanismParseContext) (Mechanism, error) {
 	}
 }
 
+// Unknown modifiers are kept with their name as long as the name is valid,
+// because RFC 7208 section 6 requires them to be ignored
 func EvaluateModifier(context *MechanismParseContext) (Mechanism, error) {
 	modifier := Mechanism{Qualifier: PassQualifier, Value: context.Value}
 
-	switch context.Mechanism {
+	switch strings.ToLower(context.Mechanism) {
 	case "redirect":
 		modifier.Mechanism = RedirectMechanism
 		return modifier, nil
+	case "exp":
+		modifier.Mechanism = ExpMechanism
+		return modifier, nil
 	default:
-		return Mechanism{}, ErrInvalidModifier
+		if !isModifierName(context.Mechanism) {
+			return Mechanism{}, ErrInvalidModifier
+		}
+
+		modifier.Mechanism = ModifierMechanism
+		modifier.Name = context.Mechanism
+		return modifier, nil
 	}
 }
+
+// Checks if name is a valid modifier name: ALPHA *( ALPHA / DIGIT / "-" / "_" / "." )
+func isModifierName(name string) bool {
+	for i, chr := range name {
+		isAlpha := chr >= 'a' && chr <= 'z' || chr >= 'A' && chr <= 'Z'
+
+		if i == 0 && !isAlpha {
+			return false
+		}
+
+		if !isAlpha && !(chr >= '0' && chr <= '9') && !strings.ContainsRune("-_.", chr) {
+			return false
+		}
+	}
+
+	return name != ""
+}
+
+// Splits "domain/24//64" into the domain-spec and both prefix lengths.
+// Missing lengths are nil
+func splitDualCIDR(value string) (string, *int, *int, error) {
+	var ip4CIDR, ip6CIDR *int
+
+	if i := strings.LastIndex(value, "//"); i >= 0 && isDigits(value[i+2:]) {
+		length, err := strconv.Atoi(value[i+2:])
+
+		if err != nil || length > 128 {
+			return "", nil, nil, ErrSyntax
+		}
+
+		value, ip6CIDR = value[:i], &length
+	}
+
+	if i := strings.LastIndexByte(value, '/'); i >= 0 && isDigits(value[i+1:]) {
+		length, err := strconv.Atoi(value[i+1:])
+
+		if err != nil || length > 32 {
+			return "", nil, nil, ErrSyntax
+		}
+
+		value, ip4CIDR = value[:i], &length
+	}
+
+	if strings.HasSuffix(value, "/") {
+		return "", nil, nil, ErrSyntax
+	}
+
+	return value, ip4CIDR, ip6CIDR, nil
+}
+
+func isDigits(value string) bool {
+	return value != "" && strings.Trim(value, "0123456789") == ""
+}
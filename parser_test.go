@@ -1,6 +1,8 @@
 package spf_test
 
 import (
+	"encoding/json"
+	"errors"
 	"reflect"
 	"testing"
 
@@ -155,3 +157,225 @@ func TestRedirectWithMechanisms(t *testing.T) {
 		t.Errorf("Not as expected: %q does not equal to %q", result, &expected)
 	}
 }
+
+func TestParseExp(t *testing.T) {
+	result, err := spf.ParseSPF("v=spf1 exp=explain._spf.%{d} -all")
+
+	var expected spf.Record = []spf.Mechanism{
+		{Qualifier: spf.FailQualifier, Mechanism: spf.AllMechanism, Value: ""},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.ExpMechanism, Value: "explain._spf.%{d}"},
+	}
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Not as expected: %q does not equal to %q", result, &expected)
+	}
+}
+
+func TestParseUnknownModifiers(t *testing.T) {
+	result, err := spf.ParseSPF("v=spf1 ra=postmaster rp=100 ip4:192.0.2.0/24 rr=e:f -all")
+
+	var expected spf.Record = []spf.Mechanism{
+		{Qualifier: spf.PassQualifier, Mechanism: spf.IPv4Mechanism, Value: "192.0.2.0/24"},
+		{Qualifier: spf.FailQualifier, Mechanism: spf.AllMechanism, Value: ""},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.ModifierMechanism, Value: "postmaster", Name: "ra"},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.ModifierMechanism, Value: "100", Name: "rp"},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.ModifierMechanism, Value: "e:f", Name: "rr"},
+	}
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Not as expected: %q does not equal to %q", result, &expected)
+	}
+}
+
+func TestParseFailInvalidModifier(t *testing.T) {
+	_, err := spf.ParseSPF("v=spf1 1ra=postmaster -all")
+
+	if err != spf.ErrInvalidModifier {
+		t.Errorf("Error should output 'invalidmodifier', got '%v' instead", err)
+	}
+}
+
+func TestParseFailDuplicateModifier(t *testing.T) {
+	for _, record := range []string{
+		"v=spf1 redirect=_spf.voulter.com redirect=_spf2.voulter.com",
+		"v=spf1 exp=a.voulter.com EXP=b.voulter.com -all",
+	} {
+		_, err := spf.ParseSPF(record)
+
+		if err != spf.ErrDuplicateModifier {
+			t.Errorf("Error should output 'duplicatemodifier', got '%v' instead", err)
+		}
+	}
+}
+
+func TestParseDualCIDR(t *testing.T) {
+	result, err := spf.ParseSPF("v=spf1 a a/24 a:voulter.com/24//64 mx//64 mx:voulter.com/28 -all")
+
+	var expected spf.Record = []spf.Mechanism{
+		{Qualifier: spf.PassQualifier, Mechanism: spf.AMechanism, Value: ""},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.AMechanism, Value: "", IP4CIDR: spf.CIDRLength(24)},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.AMechanism, Value: "voulter.com", IP4CIDR: spf.CIDRLength(24), IP6CIDR: spf.CIDRLength(64)},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.MXMechanism, Value: "", IP6CIDR: spf.CIDRLength(64)},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.MXMechanism, Value: "voulter.com", IP4CIDR: spf.CIDRLength(28)},
+		{Qualifier: spf.FailQualifier, Mechanism: spf.AllMechanism, Value: ""},
+	}
+
+	if err != nil {
+		t.Error(err)
+	}
+
+	if !reflect.DeepEqual(result, expected) {
+		t.Errorf("Not as expected: %q does not equal to %q", result, &expected)
+	}
+}
+
+func TestParseFailDualCIDR(t *testing.T) {
+	for _, record := range []string{"v=spf1 a/33", "v=spf1 mx:voulter.com//129", "v=spf1 a:voulter.com/", "v=spf1 a/24:voulter.com"} {
+		_, err := spf.ParseSPF(record)
+
+		if err != spf.ErrSyntax {
+			t.Errorf("%s: Error should output 'syntax', got '%v' instead", record, err)
+		}
+	}
+}
+
+func TestRecordString(t *testing.T) {
+	record := spf.Record{
+		{Qualifier: spf.FailQualifier, Mechanism: spf.IncludeMechanism, Value: "ban.voulter.com"},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.AMechanism, Value: "voulter.com", IP4CIDR: spf.CIDRLength(24), IP6CIDR: spf.CIDRLength(64)},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.MXMechanism},
+		{Qualifier: spf.NeutralQualifier, Mechanism: spf.IPv6Mechanism, Value: "2001:db8::/32"},
+		{Qualifier: spf.SoftFailQualifier, Mechanism: spf.AllMechanism},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.RedirectMechanism, Value: "_spf.voulter.com"},
+		{Qualifier: spf.PassQualifier, Mechanism: spf.ModifierMechanism, Name: "ra", Value: "postmaster"},
+	}
+
+	expected := "v=spf1 -include:ban.voulter.com a:voulter.com/24//64 mx ?ip6:2001:db8::/32 ~all redirect=_spf.voulter.com ra=postmaster"
+
+	if record.String() != expected {
+		t.Errorf("Not as expected: '%s' does not equal to '%s'", record.String(), expected)
+	}
+}
+
+func TestRecordStringRoundTrip(t *testing.T) {
+	for _, text := range []string{
+		"v=spf1",
+		"v=spf1 -include:ban.voulter.com include:spf.voulter.com a:voulter.com ip4:127.0.0.1 ip6:::1 ~all",
+		"v=spf1 a a/24 a:voulter.com/24//64 mx//64 mx:voulter.com/28 ptr ptr:voulter.com -all",
+		"v=spf1 +ip4:192.0.2.0/24 -exists:%{ir}.%{l1r+-}._spf.%{d} ?all exp=explain._spf.%{d}",
+		"v=spf1 ra=postmaster rp=100 redirect=_spf.voulter.com",
+		"V=SPF1 A:voulter.com MX -ALL",
+	} {
+		record, err := spf.ParseSPF(text)
+
+		if err != nil {
+			t.Errorf("%s: %v", text, err)
+			continue
+		}
+
+		again, err := spf.ParseSPF(record.String())
+
+		if err != nil {
+			t.Errorf("%s: %v", record.String(), err)
+		}
+
+		if !reflect.DeepEqual(record, again) {
+			t.Errorf("%s: Round trip over '%s' changed the record to %q", text, record.String(), again)
+		}
+	}
+}
+
+func TestKindAndQualifierString(t *testing.T) {
+	if spf.IncludeMechanism.String() != "include" || spf.ModifierMechanism.String() != "modifier" || spf.MechanismKind(42).String() != "MechanismKind(42)" {
+		t.Errorf("Unexpected names %v, %v and %v", spf.IncludeMechanism, spf.ModifierMechanism, spf.MechanismKind(42))
+	}
+
+	if spf.PassQualifier.String() != "+" || spf.SoftFailQualifier.String() != "~" || spf.Qualifier(7).String() != "Qualifier(7)" {
+		t.Errorf("Unexpected qualifiers %v, %v and %v", spf.PassQualifier, spf.SoftFailQualifier, spf.Qualifier(7))
+	}
+}
+
+func TestRecordJSON(t *testing.T) {
+	record, _ := spf.ParseSPF("v=spf1 -a:voulter.com/24 ip4:192.0.2.0/24 ~all ra=postmaster")
+	data, err := json.Marshal(record)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"qualifier":"-","kind":"a","value":"voulter.com","ip4_cidr":24},` +
+		`{"qualifier":"+","kind":"ip4","value":"192.0.2.0/24"},{"qualifier":"~","kind":"all"},` +
+		`{"kind":"modifier","name":"ra","value":"postmaster"}]`
+
+	if string(data) != expected {
+		t.Errorf("Not as expected: '%s' does not equal to '%s'", data, expected)
+	}
+
+	var again spf.Record
+
+	if err := json.Unmarshal(data, &again); err != nil || !reflect.DeepEqual(record, again) {
+		t.Errorf("Round trip changed the record to %q (%v)", again, err)
+	}
+
+	if err := json.Unmarshal([]byte(`"v=spf1 -a:voulter.com/24 ip4:192.0.2.0/24 ~all ra=postmaster"`), &again); err != nil || !reflect.DeepEqual(record, again) {
+		t.Errorf("Record text was read as %q (%v)", again, err)
+	}
+
+	text, _ := record.MarshalText()
+
+	if string(text) != record.String() {
+		t.Errorf("Text should equal String, got '%s'", text)
+	}
+}
+
+func TestMechanismUnmarshalJSON(t *testing.T) {
+	var mechanism spf.Mechanism
+
+	if err := json.Unmarshal([]byte(`{"kind":"MX"}`), &mechanism); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := (spf.Mechanism{Mechanism: spf.MXMechanism}); !reflect.DeepEqual(mechanism, expected) {
+		t.Errorf("Not as expected: %v does not equal to %v", mechanism, expected)
+	}
+
+	for data, expected := range map[string]error{
+		`{"kind":"ip5"}`:                  spf.ErrInvalidMechanism,
+		`{"qualifier":"!","kind":"all"}`:  spf.ErrInvalidQualifier,
+		`{"kind":"modifier","name":"1x"}`: spf.ErrInvalidModifier,
+		`{"kind":"a","ip4_cidr":33}`:      spf.ErrSyntax,
+		`"v=spf1 -all"`:                   nil,
+	} {
+		err := json.Unmarshal([]byte(data), &mechanism)
+
+		if expected != nil && !errors.Is(err, expected) || expected == nil && err == nil {
+			t.Errorf("%s: Unexpected error %v", data, err)
+		}
+	}
+}
+
+func TestResultJSON(t *testing.T) {
+	data, _ := json.Marshal(map[string]spf.Result{"spf": spf.SoftFailResult})
+
+	if string(data) != `{"spf":"softfail"}` {
+		t.Errorf("Unexpected JSON %s", data)
+	}
+
+	var result spf.Result
+
+	if err := json.Unmarshal([]byte(`"PermError"`), &result); err != nil || result != spf.PermErrorResult {
+		t.Errorf("Expected permerror, got %q (%v)", result, err)
+	}
+
+	if err := json.Unmarshal([]byte(`"unknown"`), &result); err != spf.ErrInvalidResult {
+		t.Errorf("Expected ErrInvalidResult, got %v", err)
+	}
+}
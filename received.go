@@ -0,0 +1,194 @@
+package spf
+
+import (
+	"net"
+	"strings"
+)
+
+// Identities which can be checked, see ReceivedSPF.Identity
+const (
+	IdentityMailFrom = "mailfrom" // The MAIL FROM address
+	IdentityHelo     = "helo"     // The domain of HELO or EHLO
+)
+
+// Maximum length of a header line before it is folded (RFC 5322 section 2.1.1)
+const headerLineLength = 78
+
+// The fields of a Received-SPF header as described in RFC 7208 section 9.1.
+// Empty fields are left out of the header
+type ReceivedSPF struct {
+	Result       Result
+	Identity     string // IdentityMailFrom or IdentityHelo
+	ClientIP     net.IP
+	EnvelopeFrom string
+	Helo         string
+	Receiver     string // Domain name of the host performing the check
+	Mechanism    string // Term which decided the result, like "-all"
+	Problem      string // Cause of a temperror or permerror result
+}
+
+// Returns the header field including the name "Received-SPF", folded to
+// lines of at most 78 characters and without trailing CRLF, for example
+//
+//	Received-SPF: pass (mybox.example.org: domain of myname@example.com designates
+//		192.0.2.1 as permitted sender) client-ip=192.0.2.1;
+//		envelope-from="myname@example.com"; helo=foo.example.com;
+//		receiver=mybox.example.org; identity=mailfrom;
+func (h ReceivedSPF) String() string {
+	return fold("Received-SPF:", h.tokens())
+}
+
+// Returns the header field body without name and folding
+func (h ReceivedSPF) Value() string {
+	return strings.Join(h.tokens(), " ")
+}
+
+// Splits the header body into the words which can be separated by folding
+func (h ReceivedSPF) tokens() []string {
+	tokens := []string{string(h.Result)}
+	comment := strings.Fields(escapeComment(h.comment()))
+
+	if len(comment) > 0 {
+		comment[0] = "(" + comment[0]
+		comment[len(comment)-1] += ")"
+		tokens = append(tokens, comment...)
+	}
+
+	pairs := []struct{ key, value string }{
+		{"client-ip", ""},
+		{"envelope-from", h.EnvelopeFrom},
+		{"helo", h.Helo},
+		{"receiver", h.Receiver},
+		{"identity", h.Identity},
+		{"mechanism", h.Mechanism},
+		{"problem", h.Problem},
+	}
+
+	if h.ClientIP != nil {
+		pairs[0].value = h.ClientIP.String()
+	}
+
+	for _, pair := range pairs {
+		if pair.value != "" {
+			tokens = append(tokens, pair.key+"="+headerValue(pair.value)+";")
+		}
+	}
+
+	return tokens
+}
+
+// Returns the explanation of the result in the comment of the header, with
+// the same wording as other implementations use
+func (h ReceivedSPF) comment() string {
+	sender := h.EnvelopeFrom
+
+	if sender == "" || h.Identity == IdentityHelo {
+		sender = h.Helo
+	}
+
+	// The address is left out if it is unknown
+	var comment, ip string
+
+	if h.ClientIP != nil {
+		ip = h.ClientIP.String() + " "
+	}
+
+	switch h.Result {
+	case PassResult:
+		comment = "domain of " + sender + " designates " + ip + "as permitted sender"
+	case FailResult:
+		comment = "domain of " + sender + " does not designate " + ip + "as permitted sender"
+	case SoftFailResult:
+		comment = "domain of transitioning " + sender + " does not designate " + ip + "as permitted sender"
+	case NeutralResult:
+		comment = ip + "is neither permitted nor denied by domain of " + sender
+	case NoneResult:
+		comment = "domain of " + sender + " does not designate permitted sender hosts"
+	case TempErrorResult:
+		comment = "error in processing during lookup of " + sender
+	case PermErrorResult:
+		comment = "permanent error in processing domain of " + sender
+	default:
+		comment = string(h.Result)
+	}
+
+	if h.Receiver != "" {
+		comment = h.Receiver + ": " + comment
+	}
+
+	return comment
+}
+
+// Escapes the characters which can't appear in a comment as is
+func escapeComment(comment string) string {
+	return strings.NewReplacer(`\`, `\\`, "(", `\(`, ")", `\)`).Replace(stripControl(comment))
+}
+
+// Returns value as dot-atom or, if it isn't one, as quoted-string
+func headerValue(value string) string {
+	if isDotAtom(value) {
+		return value
+	}
+
+	return quoteString(value)
+}
+
+// Returns value as quoted-string (RFC 5322 section 3.2.4). Control characters
+// can't be quoted and are dropped, so values of the SMTP client can't add
+// lines to the header
+func quoteString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(stripControl(value)) + `"`
+}
+
+// Removes the control characters from value, including tabs, CR and LF
+func stripControl(value string) string {
+	return strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return -1
+		}
+
+		return r
+	}, value)
+}
+
+// Checks if value is a dot-atom of RFC 5322 section 3.2.3
+func isDotAtom(value string) bool {
+	for _, atom := range strings.Split(value, ".") {
+		if atom == "" {
+			return false
+		}
+
+		for i := 0; i < len(atom); i++ {
+			c := atom[i]
+
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("!#$%&'*+-/=?^_`{|}~", c) >= 0) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// Joins name and tokens with spaces and folds the line before tokens which
+// would exceed headerLineLength
+func fold(name string, tokens []string) string {
+	var header strings.Builder
+	header.WriteString(name)
+	line := len(name)
+
+	for _, token := range tokens {
+		if line+1+len(token) > headerLineLength && line > 1 {
+			header.WriteString("\r\n\t")
+			line = 1
+		} else {
+			header.WriteByte(' ')
+			line++
+		}
+
+		header.WriteString(token)
+		line += len(token)
+	}
+
+	return header.String()
+}
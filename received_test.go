@@ -0,0 +1,81 @@
+package spf_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/moverval/go-spf"
+)
+
+func TestReceivedSPF(t *testing.T) {
+	header := spf.ReceivedSPF{
+		Result:       spf.PassResult,
+		Identity:     spf.IdentityMailFrom,
+		ClientIP:     net.ParseIP("192.0.2.1"),
+		EnvelopeFrom: "myname@example.com",
+		Helo:         "foo.example.com",
+		Receiver:     "mybox.example.org",
+	}
+
+	expected := "Received-SPF: pass (mybox.example.org: domain of myname@example.com designates\r\n" +
+		"\t192.0.2.1 as permitted sender) client-ip=192.0.2.1;\r\n" +
+		"\tenvelope-from=\"myname@example.com\"; helo=foo.example.com;\r\n" +
+		"\treceiver=mybox.example.org; identity=mailfrom;"
+
+	if header.String() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.String())
+	}
+
+	for _, line := range strings.Split(header.String(), "\r\n") {
+		if len(line) > 78 {
+			t.Errorf("Line is longer than 78 characters: %q", line)
+		}
+	}
+}
+
+func TestReceivedSPFQuoting(t *testing.T) {
+	header := spf.ReceivedSPF{
+		Result:       spf.PermErrorResult,
+		Identity:     spf.IdentityHelo,
+		ClientIP:     net.ParseIP("2001:db8::1"),
+		EnvelopeFrom: `"odd \ name"@example.com`,
+		Helo:         "mail(1).example.com",
+		Mechanism:    "include:spf.example.com",
+		Problem:      "syntax",
+	}
+
+	expected := `permerror (permanent error in processing domain of mail\(1\).example.com) ` +
+		`client-ip="2001:db8::1"; envelope-from="\"odd \\ name\"@example.com"; helo="mail(1).example.com"; ` +
+		`identity=helo; mechanism="include:spf.example.com"; problem=syntax;`
+
+	if header.Value() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.Value())
+	}
+}
+
+func TestReceivedSPFControlCharacters(t *testing.T) {
+	header := spf.ReceivedSPF{
+		Result:       spf.PassResult,
+		Identity:     spf.IdentityMailFrom,
+		EnvelopeFrom: "x@evil.com\r\nX-Spam-Flag: NO",
+		Helo:         "evil.com\x00\x7f",
+	}
+
+	expected := `pass (domain of x@evil.comX-Spam-Flag: NO designates as permitted sender) ` +
+		`envelope-from="x@evil.comX-Spam-Flag: NO"; helo="evil.com"; identity=mailfrom;`
+
+	if header.Value() != expected {
+		t.Errorf("Expected\n%s\ngot\n%s", expected, header.Value())
+	}
+
+	for i := 0; i < len(header.String()); i++ {
+		if c := header.String()[i]; (c < ' ' || c == 0x7f) && c != '\r' && c != '\n' && c != '\t' {
+			t.Errorf("Header contains control character %q", c)
+		}
+	}
+
+	if strings.Count(header.String(), "\r\n") != strings.Count(header.String(), "\r\n\t") {
+		t.Errorf("Header contains a line which isn't folded: %q", header.String())
+	}
+}
@@ -0,0 +1,103 @@
+package spf
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+)
+
+// The result of check_host() as defined in RFC 7208 section 2.6
+type Result string
+
+const (
+	NoneResult      Result = "none"      // No spf record was found or the domain is malformed
+	NeutralResult   Result = "neutral"   // The domain makes no assertion about the ip
+	PassResult      Result = "pass"      // The ip is authorized to send mail for the domain
+	FailResult      Result = "fail"      // The ip is not authorized to send mail for the domain
+	SoftFailResult  Result = "softfail"  // The ip is probably not authorized to send mail for the domain
+	TempErrorResult Result = "temperror" // A transient error occurred, mostly while querying dns
+	PermErrorResult Result = "permerror" // The record of the domain could not be interpreted
+)
+
+// Returns the result as it appears in headers, like "softfail"
+func (r Result) MarshalText() ([]byte, error) {
+	return []byte(r), nil
+}
+
+// Returns the result as JSON string, like "softfail"
+func (r Result) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(r))
+}
+
+// Reads one of the results of RFC 7208 section 2.6 from a JSON string, ignoring the case
+//
+// Returns ErrInvalidResult for other values
+func (r *Result) UnmarshalJSON(data []byte) error {
+	var text string
+
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+
+	result := Result(strings.ToLower(text))
+
+	if !result.valid() {
+		return ErrInvalidResult
+	}
+
+	*r = result
+	return nil
+}
+
+// Checks if the result is one of the seven results of RFC 7208 section 2.6
+func (r Result) valid() bool {
+	switch r {
+	case NoneResult, NeutralResult, PassResult, FailResult, SoftFailResult, TempErrorResult, PermErrorResult:
+		return true
+	default:
+		return false
+	}
+}
+
+// Errors which are caused by the published records. Retrying won't change anything
+var permanentErrors = []error{
+	ErrNoSPF,
+	ErrSyntax,
+	ErrInvalidQualifier,
+	ErrInvalidMechanism,
+	ErrInvalidModifier,
+	ErrDuplicateModifier,
+	ErrOutOfRecursions,
+	ErrInvalidMacro,
+	ErrTooManyLookups,
+	ErrTooManyVoidLookups,
+	ErrMissingRecord,
+	ErrMultipleRecords,
+	ErrTooManyMXHosts,
+}
+
+// Returns the result a mechanism has if it matches
+func (q Qualifier) Result() Result {
+	switch q {
+	case PassQualifier:
+		return PassResult
+	case FailQualifier:
+		return FailResult
+	case SoftFailQualifier:
+		return SoftFailResult
+	default:
+		return NeutralResult
+	}
+}
+
+// Returns permerror for errors in the records and temperror for all other
+// errors, which are mostly caused by the resolver
+func errorResult(err error) Result {
+	for _, permanent := range permanentErrors {
+		if errors.Is(err, permanent) {
+			return PermErrorResult
+		}
+	}
+
+	return TempErrorResult
+}
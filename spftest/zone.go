@@ -0,0 +1,106 @@
+// Package spftest provides a resolver with fixed records, so code which
+// evaluates spf records can be tested without network access
+package spftest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/moverval/go-spf"
+)
+
+// Error of queries which didn't get an answer in time. Queries of names
+// which fail with ErrTimeout hang until their context is done
+var ErrTimeout = errors.New("timeout")
+
+var _ spf.Resolver = (*Zone)(nil)
+
+// Zone is a spf.Resolver which answers from the records in its maps
+//
+// The keys of the maps are lowercase domain names without trailing dot,
+// ptr records are stored under the reverse name like 10.2.0.192.in-addr.arpa.
+// Names which are no key of any map don't exist and their queries fail with
+// spf.ErrNotFound (NXDOMAIN), names with records of other types only return
+// no answers. A Zone must not be modified while it is queried
+type Zone struct {
+	TXT    map[string][]string // Texts of the txt records, one entry per record
+	A      map[string][]net.IP // Addresses of the a records
+	AAAA   map[string][]net.IP // Addresses of the aaaa records
+	MX     map[string][]string // Exchange hosts of the mx records
+	PTR    map[string][]string // Host names of the ptr records
+	Errors map[string]error    // Error of every query of a name, for example spf.ErrServerFailure or ErrTimeout
+}
+
+func (z *Zone) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	return lookup(ctx, z, z.TXT, name)
+}
+
+func (z *Zone) LookupA(ctx context.Context, name string) ([]net.IP, error) {
+	return lookup(ctx, z, z.A, name)
+}
+
+func (z *Zone) LookupAAAA(ctx context.Context, name string) ([]net.IP, error) {
+	return lookup(ctx, z, z.AAAA, name)
+}
+
+func (z *Zone) LookupMX(ctx context.Context, name string) ([]string, error) {
+	return lookup(ctx, z, z.MX, name)
+}
+
+func (z *Zone) LookupPTR(ctx context.Context, name string) ([]string, error) {
+	return lookup(ctx, z, z.PTR, name)
+}
+
+// Returns the records of name in records or the error the zone has for it
+func lookup[T any](ctx context.Context, z *Zone, records map[string][]T, name string) ([]T, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	if err := z.Errors[name]; err != nil {
+		if err == ErrTimeout {
+			return nil, timeout(ctx)
+		}
+
+		return nil, err
+	}
+
+	if !z.exists(name) {
+		return nil, spf.ErrNotFound
+	}
+
+	return records[name], nil
+}
+
+// Checks if name has records of any type
+func (z *Zone) exists(name string) bool {
+	if _, ok := z.TXT[name]; ok {
+		return true
+	}
+
+	if _, ok := z.A[name]; ok {
+		return true
+	}
+
+	if _, ok := z.AAAA[name]; ok {
+		return true
+	}
+
+	if _, ok := z.MX[name]; ok {
+		return true
+	}
+
+	_, ok := z.PTR[name]
+	return ok
+}
+
+// Waits until ctx is done like a query to an unreachable nameserver. Contexts
+// which are never done return ErrTimeout immediately
+func timeout(ctx context.Context) error {
+	if ctx.Done() == nil {
+		return ErrTimeout
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
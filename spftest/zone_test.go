@@ -0,0 +1,57 @@
+package spftest_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+)
+
+func TestZone(t *testing.T) {
+	zone := &spftest.Zone{
+		TXT: map[string][]string{"voulter.com": {"v=spf1 a -all"}},
+		A:   map[string][]net.IP{"voulter.com": {net.ParseIP("192.0.2.10")}},
+		Errors: map[string]error{
+			"fail.voulter.com": spf.ErrServerFailure,
+		},
+	}
+
+	texts, err := zone.LookupTXT(context.Background(), "Voulter.com.")
+
+	if err != nil || len(texts) != 1 || texts[0] != "v=spf1 a -all" {
+		t.Errorf("Expected 'v=spf1 a -all', got %q (%v) instead", texts, err)
+	}
+
+	// The name exists, but has no mx records
+	hosts, err := zone.LookupMX(context.Background(), "voulter.com")
+
+	if err != nil || len(hosts) != 0 {
+		t.Errorf("Expected no answers, got %q (%v) instead", hosts, err)
+	}
+
+	if _, err := zone.LookupA(context.Background(), "mail.voulter.com"); err != spf.ErrNotFound {
+		t.Errorf("Error should be 'notfound', got '%v' instead", err)
+	}
+
+	if _, err := zone.LookupTXT(context.Background(), "fail.voulter.com"); err != spf.ErrServerFailure {
+		t.Errorf("Error should be 'serverfailure', got '%v' instead", err)
+	}
+}
+
+func TestZoneTimeout(t *testing.T) {
+	zone := &spftest.Zone{Errors: map[string]error{"voulter.com": spftest.ErrTimeout}}
+
+	if _, err := zone.LookupTXT(context.Background(), "voulter.com"); err != spftest.ErrTimeout {
+		t.Errorf("Error should be 'timeout', got '%v' instead", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := zone.LookupTXT(ctx, "voulter.com"); err != context.DeadlineExceeded {
+		t.Errorf("Error should be the deadline of the context, got '%v' instead", err)
+	}
+}
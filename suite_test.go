@@ -0,0 +1,238 @@
+package spf_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+	"gopkg.in/yaml.v3"
+)
+
+// A document of the openspf test suites. Every document has its own zone
+type suite struct {
+	Description string
+	Tests       map[string]scenario
+	ZoneData    map[string][]map[string]interface{} `yaml:"zonedata"`
+}
+
+type scenario struct {
+	Description string
+	Spec        oneOrMore
+	Helo        string
+	Host        string
+	MailFrom    string    `yaml:"mailfrom"`
+	Result      oneOrMore // Any of these results is correct
+	Explanation string
+}
+
+// Value of the suites which is either a single string or a list of them
+type oneOrMore []string
+
+func (o *oneOrMore) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		*o = oneOrMore{node.Value}
+		return nil
+	}
+
+	var values []string
+
+	if err := node.Decode(&values); err != nil {
+		return err
+	}
+
+	*o = values
+	return nil
+}
+
+func TestRFC7208Suite(t *testing.T) {
+	runSuite(t, "testdata/rfc7208-tests.yml", "RFC 7208")
+}
+
+func TestRFC4408Suite(t *testing.T) {
+	runSuite(t, "testdata/rfc4408-tests.yml", "RFC 4408")
+}
+
+// Runs every scenario of the suites in path. Failures report the section of rfc they cover
+func runSuite(t *testing.T, path string, rfc string) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+
+	for {
+		var s suite
+
+		if err := decoder.Decode(&s); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+
+		checker := spf.NewChecker(suiteZone(s.ZoneData))
+		checker.Strict = true
+
+		for name, test := range s.Tests {
+			test := test
+			spec := fmt.Sprintf("%s section %s", rfc, strings.Join(test.Spec, ", "))
+
+			t.Run(name, func(t *testing.T) {
+				t.Logf("%s: %s", spec, strings.TrimSpace(s.Description+" "+test.Description))
+
+				domain := test.Helo
+
+				if test.MailFrom != "" {
+					domain = test.MailFrom[strings.LastIndexByte(test.MailFrom, '@')+1:]
+				}
+
+				result, explanation, err := checker.CheckHost(context.Background(), net.ParseIP(test.Host), domain, test.MailFrom, test.Helo)
+
+				// Explanations can contain domain names like the nibbles of the
+				// i macro, whose case doesn't matter
+				if !containsResult(test.Result, result) {
+					t.Errorf("%s: Expected %q, got %q (%v)", spec, test.Result, result, err)
+				} else if result == spf.FailResult && test.Explanation != "" && test.Explanation != "DEFAULT" && !strings.EqualFold(explanation, test.Explanation) {
+					t.Errorf("%s: Expected explanation %q, got %q", spec, test.Explanation, explanation)
+				}
+			})
+		}
+	}
+}
+
+func containsResult(results []string, result spf.Result) bool {
+	for _, r := range results {
+		if spf.Result(r) == result {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Converts the zone data of a suite to a zone
+//
+// Like the reference implementation does, names with spf but without txt
+// records get txt records with the same text. A txt record NONE means the
+// name has no txt record, TIMEOUT makes the txt queries of a name time out
+// if it has no txt record and CNAME copies the records of the target
+func suiteZone(data map[string][]map[string]interface{}) *spftest.Zone {
+	zone := &spftest.Zone{
+		TXT:    map[string][]string{},
+		A:      map[string][]net.IP{},
+		AAAA:   map[string][]net.IP{},
+		MX:     map[string][]string{},
+		PTR:    map[string][]string{},
+		Errors: map[string]error{},
+	}
+
+	cnames := map[string]string{}
+
+	for name, records := range data {
+		name = strings.ToLower(strings.TrimSuffix(name, "."))
+		var spfs []string
+		txtSeen := false
+		timeout := false
+
+		for _, record := range records {
+			for kind, value := range record {
+				switch kind {
+				case "SPF":
+					spfs = append(spfs, suiteText(value))
+				case "TXT":
+					txtSeen = true
+					zone.TXT[name] = zone.TXT[name]
+
+					if value != "NONE" {
+						zone.TXT[name] = append(zone.TXT[name], suiteText(value))
+					}
+				case "A":
+					zone.A[name] = append(zone.A[name], suiteIPs(value)...)
+				case "AAAA":
+					zone.AAAA[name] = append(zone.AAAA[name], suiteIPs(value)...)
+				case "MX":
+					mx := value.([]interface{})
+					zone.MX[name] = append(zone.MX[name], fmt.Sprint(mx[1]))
+				case "PTR":
+					zone.PTR[name] = append(zone.PTR[name], fmt.Sprint(value))
+				case "CNAME":
+					cnames[name] = strings.ToLower(strings.TrimSuffix(fmt.Sprint(value), "."))
+				case "CNAMELOOP":
+					zone.Errors[name] = spf.ErrServerFailure
+				case "TIMEOUT":
+					timeout = true
+				}
+			}
+		}
+
+		if !txtSeen && len(spfs) > 0 {
+			zone.TXT[name] = spfs
+		}
+
+		if timeout && len(zone.TXT[name]) == 0 {
+			zone.Errors[name] = spftest.ErrTimeout
+		}
+	}
+
+	for alias, target := range cnames {
+		for i := 0; i < 10; i++ {
+			if next, ok := cnames[target]; ok {
+				target = next
+			}
+		}
+
+		if err, ok := zone.Errors[target]; ok {
+			zone.Errors[alias] = err
+		}
+
+		zone.TXT[alias] = zone.TXT[target]
+		zone.A[alias] = zone.A[target]
+		zone.AAAA[alias] = zone.AAAA[target]
+		zone.MX[alias] = zone.MX[target]
+		zone.PTR[alias] = zone.PTR[target]
+	}
+
+	return zone
+}
+
+// Returns the text of a txt record. Records of several strings are joined
+func suiteText(value interface{}) string {
+	if parts, ok := value.([]interface{}); ok {
+		var text strings.Builder
+
+		for _, part := range parts {
+			text.WriteString(fmt.Sprint(part))
+		}
+
+		return text.String()
+	}
+
+	return fmt.Sprint(value)
+}
+
+func suiteIPs(value interface{}) []net.IP {
+	var ips []net.IP
+
+	if values, ok := value.([]interface{}); ok {
+		for _, v := range values {
+			ips = append(ips, suiteIPs(v)...)
+		}
+
+		return ips
+	}
+
+	if ip := net.ParseIP(fmt.Sprint(value)); ip != nil {
+		ips = append(ips, ip)
+	}
+
+	return ips
+}
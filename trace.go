@@ -0,0 +1,288 @@
+package spf
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Trace of an evaluation which shows how its result came about. See Checker.Trace
+type Trace struct {
+	IP          net.IP
+	Result      Result
+	Explanation string
+	Err         error
+	Lookups     int          // Terms which queried dns, MaxLookups + 1 if the limit was exceeded
+	VoidLookups int          // Lookups without answers, MaxVoidLookups + 1 if the limit was exceeded
+	Root        *DomainTrace // Record of the domain the evaluation started with
+	Queries     []Query      // Queries after the evaluation of the records, like the ones of the default explanation
+}
+
+// Trace of the evaluation of the record of a single domain
+type DomainTrace struct {
+	Domain   string
+	Queries  []Query       // Queries for the record
+	Record   string        // Text of the record, empty if none was found
+	Warnings []*ParseError // Problems of the record which only a strict checker rejects
+	Terms    []*TermTrace
+	Match    *TermTrace // Term which decided the result. Nil if no term matched
+	Result   Result
+	Err      error
+}
+
+// Trace of a mechanism or redirect of a record
+type TermTrace struct {
+	Mechanism Mechanism
+	Domain    string  // Target domain after macro expansion, empty for all, ip4 and ip6
+	Queries   []Query // Queries of the term, including the ones of macros and the explanation of a fail
+	Result    Result  // none if the term didn't match
+	Err       error
+	Lookups   int          // Lookups of the evaluation after the term
+	Target    *DomainTrace // Record of the target of an include or redirect
+}
+
+// A dns query and its answers
+type Query struct {
+	Type    string // TXT, A, AAAA, MX or PTR
+	Name    string
+	Answers []string
+	Err     error
+}
+
+// Evaluates the spf record of domain like CheckHost and returns a trace of
+// every record, term and dns query of the evaluation
+func (c *Checker) Trace(ctx context.Context, ip net.IP, domain string, sender string, helo string) *Trace {
+	trace := &Trace{IP: ip, Root: &DomainTrace{Domain: domain}}
+	trace.Result, trace.Explanation, trace.Err = c.check(ctx, ip, domain, sender, helo, trace)
+	return trace
+}
+
+// Returns the terms which decided the result, from the term of the first
+// record down to the term of the included or redirected record which matched
+func (t *Trace) Decision() []*TermTrace {
+	var terms []*TermTrace
+
+	for domain := t.Root; domain != nil && domain.Match != nil; domain = domain.Match.Target {
+		terms = append(terms, domain.Match)
+	}
+
+	return terms
+}
+
+// Renders the trace as indented text, one line per record, term and query
+func (t *Trace) String() string {
+	var text strings.Builder
+
+	fmt.Fprintf(&text, "result %s for %s", t.Result, t.IP)
+
+	if t.Err != nil {
+		fmt.Fprintf(&text, " (%v)", t.Err)
+	}
+
+	fmt.Fprintf(&text, "\nlookups %d/%d, void lookups %d/%d\n", t.Lookups, MaxLookups, t.VoidLookups, MaxVoidLookups)
+
+	if t.Explanation != "" {
+		fmt.Fprintf(&text, "explanation %q\n", t.Explanation)
+	}
+
+	t.Root.write(&text, "")
+
+	for _, query := range t.Queries {
+		query.write(&text, "")
+	}
+
+	return text.String()
+}
+
+func (d *DomainTrace) write(text *strings.Builder, indent string) {
+	fmt.Fprintf(text, "%sdomain %s: %s", indent, d.Domain, d.Result)
+
+	if d.Err != nil {
+		fmt.Fprintf(text, " (%v)", d.Err)
+	}
+
+	text.WriteByte('\n')
+	indent += "  "
+
+	for _, query := range d.Queries {
+		query.write(text, indent)
+	}
+
+	if d.Record != "" {
+		fmt.Fprintf(text, "%srecord %q\n", indent, d.Record)
+	}
+
+	for _, warning := range d.Warnings {
+		fmt.Fprintf(text, "%swarning %v\n", indent, warning)
+	}
+
+	for _, term := range d.Terms {
+		fmt.Fprintf(text, "%sterm %s: %s", indent, term.Mechanism, term.Result)
+
+		if term.Err != nil {
+			fmt.Fprintf(text, " (%v)", term.Err)
+		}
+
+		fmt.Fprintf(text, ", lookups %d", term.Lookups)
+
+		if term == d.Match {
+			text.WriteString(", decisive")
+		}
+
+		text.WriteByte('\n')
+
+		for _, query := range term.Queries {
+			query.write(text, indent+"  ")
+		}
+
+		if term.Target != nil {
+			term.Target.write(text, indent+"  ")
+		}
+	}
+}
+
+func (q Query) write(text *strings.Builder, indent string) {
+	fmt.Fprintf(text, "%squery %s %s: ", indent, q.Type, q.Name)
+
+	switch {
+	case q.Err != nil:
+		fmt.Fprintf(text, "%v", q.Err)
+	case len(q.Answers) == 0:
+		text.WriteString("no answers")
+	default:
+		for i, answer := range q.Answers {
+			if i > 0 {
+				text.WriteString(", ")
+			}
+
+			fmt.Fprintf(text, "%q", answer)
+		}
+	}
+
+	text.WriteByte('\n')
+}
+
+// Resolver which records every query in the trace of the record or term
+// evaluated at the moment
+type traceResolver struct {
+	resolver Resolver
+	queries  *[]Query
+}
+
+func (r *traceResolver) record(qtype string, name string, answers []string, err error) {
+	if r.queries != nil {
+		*r.queries = append(*r.queries, Query{Type: qtype, Name: name, Answers: answers, Err: err})
+	}
+}
+
+func (r *traceResolver) LookupTXT(ctx context.Context, name string) ([]string, error) {
+	texts, err := r.resolver.LookupTXT(ctx, name)
+	r.record("TXT", name, texts, err)
+	return texts, err
+}
+
+func (r *traceResolver) LookupA(ctx context.Context, name string) ([]net.IP, error) {
+	ips, err := r.resolver.LookupA(ctx, name)
+	r.record("A", name, ipStrings(ips), err)
+	return ips, err
+}
+
+func (r *traceResolver) LookupAAAA(ctx context.Context, name string) ([]net.IP, error) {
+	ips, err := r.resolver.LookupAAAA(ctx, name)
+	r.record("AAAA", name, ipStrings(ips), err)
+	return ips, err
+}
+
+func (r *traceResolver) LookupMX(ctx context.Context, name string) ([]string, error) {
+	hosts, err := r.resolver.LookupMX(ctx, name)
+	r.record("MX", name, hosts, err)
+	return hosts, err
+}
+
+func (r *traceResolver) LookupPTR(ctx context.Context, name string) ([]string, error) {
+	hosts, err := r.resolver.LookupPTR(ctx, name)
+	r.record("PTR", name, hosts, err)
+	return hosts, err
+}
+
+func ipStrings(ips []net.IP) []string {
+	var texts []string
+
+	for _, ip := range ips {
+		texts = append(texts, ip.String())
+	}
+
+	return texts
+}
+
+// The following methods do nothing if the evaluation isn't traced
+
+// Starts the trace of the record of eval.Domain
+func (eval *Evaluation) traceDomain() {
+	if eval.trace != nil {
+		eval.recorder.queries = &eval.trace.Queries
+	}
+}
+
+func (eval *Evaluation) traceRecord(record string) {
+	if eval.trace != nil {
+		eval.trace.Record = record
+	}
+}
+
+func (eval *Evaluation) traceWarnings(warnings []*ParseError) {
+	if eval.trace != nil {
+		eval.trace.Warnings = warnings
+	}
+}
+
+// Starts the trace of a term of the record of eval.Domain
+func (eval *Evaluation) traceTerm(mechanism Mechanism) {
+	if eval.trace == nil {
+		return
+	}
+
+	eval.term = &TermTrace{Mechanism: mechanism}
+	eval.trace.Terms = append(eval.trace.Terms, eval.term)
+	eval.recorder.queries = &eval.term.Queries
+}
+
+// Records the following queries for the term which was started last again,
+// after the record of an include or redirect was evaluated or to explain its fail
+func (eval *Evaluation) traceResume() {
+	if eval.trace != nil && eval.term != nil {
+		eval.recorder.queries = &eval.term.Queries
+	}
+}
+
+// Stores the result of the term which was started last. A result other than
+// none decides the result of the record
+func (eval *Evaluation) traceTermResult(result Result, err error) {
+	if eval.trace == nil {
+		return
+	}
+
+	eval.term.Result, eval.term.Err = result, err
+
+	if eval.Budget != nil {
+		eval.term.Lookups = eval.Budget.Lookups
+	}
+
+	if result != NoneResult {
+		eval.trace.Match = eval.term
+	}
+}
+
+func (eval *Evaluation) traceTarget(domain string) {
+	if eval.trace != nil {
+		eval.term.Domain = domain
+	}
+}
+
+// Stores the result of the record of eval.Domain
+func (eval *Evaluation) traceResult(result Result, err error) {
+	if eval.trace != nil {
+		eval.trace.Result, eval.trace.Err = result, err
+	}
+}
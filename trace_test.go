@@ -0,0 +1,147 @@
+package spf_test
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+)
+
+func TestTrace(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 a:mail.voulter.com include:spf.voulter.com ~all"},
+			"spf.voulter.com": {"v=spf1 ip4:198.51.100.0/24 -all"},
+		},
+		A: map[string][]net.IP{"mail.voulter.com": {net.ParseIP("198.51.100.10")}},
+	})
+
+	trace := checker.Trace(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if trace.Result != spf.SoftFailResult || trace.Lookups != 2 {
+		t.Errorf("Expected softfail after 2 lookups, got %q after %d", trace.Result, trace.Lookups)
+	}
+
+	root := trace.Root
+
+	if root.Record != "v=spf1 a:mail.voulter.com include:spf.voulter.com ~all" || len(root.Terms) != 3 {
+		t.Fatalf("Unexpected trace of voulter.com: %+v", root)
+	}
+
+	a := root.Terms[0]
+
+	if len(a.Queries) != 1 || a.Queries[0].Type != "A" || a.Queries[0].Answers[0] != "198.51.100.10" {
+		t.Errorf("Expected the a query of mail.voulter.com, got %+v", a.Queries)
+	}
+
+	include := root.Terms[1]
+
+	if include.Domain != "spf.voulter.com" || include.Result != spf.NoneResult || include.Target == nil || include.Target.Result != spf.FailResult {
+		t.Errorf("Unexpected trace of the include: %+v", include)
+	}
+
+	decision := trace.Decision()
+
+	if len(decision) != 1 || decision[0] != root.Terms[2] {
+		t.Errorf("~all should have decided the result, got %+v", decision)
+	}
+
+	text := trace.String()
+
+	for _, line := range []string{
+		"result softfail for 192.0.2.10",
+		"  term include:spf.voulter.com: none, lookups 2",
+		"      term -all: fail, lookups 2, decisive",
+		"  term ~all: softfail, lookups 2, decisive",
+		`    query A mail.voulter.com: "198.51.100.10"`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("Rendered trace doesn't contain %q:\n%s", line, text)
+		}
+	}
+}
+
+func TestTraceDecision(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":      {"v=spf1 redirect=_spf.voulter.com"},
+			"_spf.voulter.com": {"v=spf1 include:spf.voulter.com -all"},
+			"spf.voulter.com":  {"v=spf1 ip4:192.0.2.0/24 -all"},
+		},
+	})
+
+	trace := checker.Trace(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+	decision := trace.Decision()
+	expected := []spf.MechanismKind{spf.RedirectMechanism, spf.IncludeMechanism, spf.IPv4Mechanism}
+
+	if trace.Result != spf.PassResult || len(decision) != len(expected) {
+		t.Fatalf("Expected pass decided by 3 terms, got %q and %+v", trace.Result, decision)
+	}
+
+	for i, term := range decision {
+		if term.Mechanism.Mechanism != expected[i] {
+			t.Errorf("Term %d should be mechanism %v, got %v", i, expected[i], term.Mechanism.Mechanism)
+		}
+	}
+}
+
+func TestTraceExplanationQueries(t *testing.T) {
+	zone := &spftest.Zone{
+		TXT: map[string][]string{
+			"c.com":   {"v=spf1 exp=e.c.com -include:d.com"},
+			"d.com":   {"v=spf1 ip4:192.0.2.10"},
+			"e.c.com": {"denied by c.com"},
+		},
+	}
+
+	trace := spf.NewChecker(zone).Trace(context.Background(), net.ParseIP("192.0.2.10"), "c.com", "info@c.com", "mail.c.com")
+
+	if trace.Result != spf.FailResult || trace.Explanation != "denied by c.com" {
+		t.Fatalf("Expected fail explained by c.com, got %q with %q", trace.Result, trace.Explanation)
+	}
+
+	include := trace.Root.Terms[0]
+	ip4 := include.Target.Terms[0]
+
+	if len(include.Queries) != 1 || include.Queries[0].Name != "e.c.com" || len(ip4.Queries) != 0 {
+		t.Errorf("The exp query should belong to the include, got %+v and %+v", include.Queries, ip4.Queries)
+	}
+
+	// The default explanation is explained by no record
+	zone.TXT["c.com"] = []string{"v=spf1 -include:d.com"}
+	checker := spf.NewChecker(zone)
+	checker.DefaultExplanation = "%{p} is not allowed"
+	trace = checker.Trace(context.Background(), net.ParseIP("192.0.2.10"), "c.com", "info@c.com", "mail.c.com")
+	include = trace.Root.Terms[0]
+
+	if len(trace.Queries) != 1 || trace.Queries[0].Type != "PTR" || len(include.Queries) != 0 || len(include.Target.Terms[0].Queries) != 0 {
+		t.Errorf("The ptr query should belong to the trace, got %+v", trace.Queries)
+	}
+
+	if !strings.Contains(trace.String(), "\nquery PTR 10.2.0.192.in-addr.arpa: ") {
+		t.Errorf("Trace misses the ptr query:\n%s", trace)
+	}
+}
+
+func TestTraceWarnings(t *testing.T) {
+	checker := spf.NewChecker(&spftest.Zone{
+		TXT: map[string][]string{
+			"voulter.com":     {"v=spf1 include:spf.voulter.com -all"},
+			"spf.voulter.com": {"v=spf1 ip4:192.0.2.0/24 a/032 -all"},
+		},
+	})
+
+	trace := checker.Trace(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+	target := trace.Root.Terms[0].Target
+
+	if trace.Result != spf.PassResult || len(trace.Root.Warnings) != 0 || target == nil || len(target.Warnings) != 1 {
+		t.Fatalf("Expected a warning for spf.voulter.com, got %q with %+v", trace.Result, trace.Root)
+	}
+
+	if line := `    warning column 26: syntax at "/032", expected prefix lengths without leading zeros`; !strings.Contains(trace.String(), line+"\n") {
+		t.Errorf("Trace should contain %q:\n%s", line, trace.String())
+	}
+}
@@ -0,0 +1,611 @@
+package spf
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Options of ParseTree and ParseSPF
+type ParseOptions struct {
+	// Reject every record the ABNF of RFC 7208 section 12 rejects, like bad
+	// prefix lengths, domain-specs and addresses or stray characters. Without
+	// it such records are interpreted as well as possible and the problems
+	// are reported as warnings
+	Strict bool
+}
+
+// Syntax tree of a record. It keeps the whole text, so every term can be
+// mapped back to its position, for example to underline it in an editor
+type Tree struct {
+	Text    string      // The record as given to ParseTree
+	Version Span        // Position of "v=spf1"
+	Terms   []*TermNode // Mechanisms and modifiers in the order of the text
+
+	Warnings []*ParseError // Problems which a strict parse rejects
+}
+
+// Part of a record given by byte offsets. An empty span means the part is missing
+type Span struct {
+	Start int // Offset of the first byte
+	End   int // Offset after the last byte
+}
+
+// A mechanism or modifier of a Tree. The embedded Mechanism holds its meaning
+// like ParseSPF returns it, the spans where its parts are written
+type TermNode struct {
+	Mechanism
+	Text          string // The term as written in the record
+	Span          Span   // Position of the whole term
+	QualifierSpan Span   // Position of the qualifier, empty if it was left out
+	NameSpan      Span   // Position of the name of the mechanism or modifier
+	ValueSpan     Span   // Position of the domain-spec, network or macro-string, without prefix lengths
+	CIDRSpan      Span   // Position of the prefix lengths of a and mx, like "/24//64"
+}
+
+// Syntax error of a record with its position
+type ParseError struct {
+	Err      error  // The cause, like ErrSyntax or ErrInvalidMechanism
+	Offset   int    // Byte offset of the error in the record
+	Column   int    // Column of the error in characters, starting at 1
+	Token    string // The term or character which caused the error
+	Expected string // Description of what was expected instead
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("column %d: %v at %q, expected %s", e.Column, e.Err, e.Token, e.Expected)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Checks if the span covers no text
+func (s Span) Empty() bool {
+	return s.End <= s.Start
+}
+
+// JSON object of a term node, the spans which are empty are left out
+type termNodeJSON struct {
+	Mechanism     Mechanism `json:"mechanism"`
+	Text          string    `json:"text"`
+	Span          *spanJSON `json:"span"`
+	QualifierSpan *spanJSON `json:"qualifier_span,omitempty"`
+	NameSpan      *spanJSON `json:"name_span,omitempty"`
+	ValueSpan     *spanJSON `json:"value_span,omitempty"`
+	CIDRSpan      *spanJSON `json:"cidr_span,omitempty"`
+}
+
+type spanJSON struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+func newSpanJSON(span Span) *spanJSON {
+	if span.Empty() {
+		return nil
+	}
+
+	return &spanJSON{span.Start, span.End}
+}
+
+// Returns the node as JSON object with the mechanism and its position, for example
+//
+//	{"mechanism":{"qualifier":"+","kind":"mx","ip4_cidr":28},"text":"mx/28",
+//	 "span":{"start":7,"end":12},"name_span":{"start":7,"end":9},"cidr_span":{"start":9,"end":12}}
+//
+// Without it the node would be written like its embedded Mechanism
+func (n TermNode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(termNodeJSON{
+		Mechanism:     n.Mechanism,
+		Text:          n.Text,
+		Span:          &spanJSON{n.Span.Start, n.Span.End},
+		QualifierSpan: newSpanJSON(n.QualifierSpan),
+		NameSpan:      newSpanJSON(n.NameSpan),
+		ValueSpan:     newSpanJSON(n.ValueSpan),
+		CIDRSpan:      newSpanJSON(n.CIDRSpan),
+	})
+}
+
+// Parses a record into a syntax tree. It accepts the same records as ParseSPF
+//
+// Returns a *ParseError if the record is invalid
+func ParseTree(spf string) (*Tree, error) {
+	return ParseOptions{}.ParseTree(spf)
+}
+
+// Same as ParseSPF, but returns the problems of the record as warnings if
+// the options aren't strict
+func (o ParseOptions) ParseSPF(spf string) (Record, []*ParseError, error) {
+	tree, err := o.ParseTree(spf)
+
+	if err != nil {
+		return nil, nil, err.(*ParseError).Err
+	}
+
+	return tree.Record(), tree.Warnings, nil
+}
+
+// Same as ParseTree, but follows the options
+func (o ParseOptions) ParseTree(spf string) (*Tree, error) {
+	if !IsSPF(spf) {
+		token, _, _ := strings.Cut(spf, " ")
+		return nil, &ParseError{Err: ErrNoSPF, Column: 1, Token: token, Expected: `"v=spf1"`}
+	}
+
+	tree := &Tree{Text: spf, Version: Span{0, len("v=spf1")}}
+	parser := treeParser{tree: tree, options: o}
+	parser.reset()
+
+	for i := len("v=spf "); i < len(spf); {
+		chr, size := utf8.DecodeRuneInString(spf[i:])
+		context := &parser.context
+
+		switch chr {
+		case '+', '-', '~', '?':
+			if context.Mechanism == "" && context.Value == "" {
+				if !parser.term.QualifierSpan.Empty() {
+					if err := parser.problem(ErrSyntax, i, i+size, "a single qualifier"); err != nil {
+						return nil, err
+					}
+				}
+
+				qualifier, err := EvaluateQualifier(chr)
+
+				if err != nil {
+					return nil, parser.error(err, i, i+size, "qualifier")
+				}
+
+				context.Qualifier = qualifier
+				parser.term.QualifierSpan = parser.span(i, size)
+				break
+			}
+
+			parser.write(i, size)
+		case ':', '=':
+			if context.Mechanism == "" {
+				return nil, parser.error(ErrSyntax, i, i+size, "mechanism or modifier name before "+string(chr))
+			}
+
+			if !context.WritingDescriptor {
+				parser.write(i, size)
+				break
+			}
+
+			context.WritingDescriptor = false
+			context.Modifier = chr == '='
+			parser.span(i, size)
+		case ' ', '\n', '\r':
+			if err := parser.checkSpace(chr, i, size); err != nil {
+				return nil, err
+			}
+
+			// Whitespace after "include:" is skipped, but bare mechanisms like "a" end here
+			if context.Mechanism == "" || !context.WritingDescriptor && context.Value == "" {
+				break
+			}
+
+			if err := parser.finish(); err != nil {
+				return nil, err
+			}
+		default:
+			parser.write(i, size)
+		}
+
+		i += size
+	}
+
+	if parser.context.Mechanism != "" {
+		if err := parser.finish(); err != nil {
+			return nil, err
+		}
+	} else if qualifier := parser.term.QualifierSpan; !qualifier.Empty() {
+		if err := parser.problem(ErrSyntax, qualifier.Start, qualifier.End, "a mechanism after the qualifier"); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := map[MechanismKind]bool{}
+
+	for _, node := range tree.Terms {
+		if node.Mechanism.Mechanism != RedirectMechanism && node.Mechanism.Mechanism != ExpMechanism {
+			continue
+		}
+
+		if seen[node.Mechanism.Mechanism] {
+			return nil, parser.error(ErrDuplicateModifier, node.Span.Start, node.Span.End, "at most one redirect and one exp modifier")
+		}
+
+		seen[node.Mechanism.Mechanism] = true
+	}
+
+	return tree, nil
+}
+
+// Returns the record of the tree like ParseSPF does, with the modifiers
+// after the mechanisms
+func (t *Tree) Record() Record {
+	record := Record{}
+
+	for _, node := range t.Terms {
+		if !node.IsModifier() {
+			record = append(record, node.Mechanism)
+		}
+	}
+
+	for _, node := range t.Terms {
+		if node.IsModifier() {
+			record = append(record, node.Mechanism)
+		}
+	}
+
+	return record
+}
+
+// State of ParseTree while it reads a term
+type treeParser struct {
+	tree    *Tree
+	options ParseOptions
+	context MechanismParseContext
+	term    *TermNode
+}
+
+// Starts the next term
+func (p *treeParser) reset() {
+	p.context = MechanismParseContext{Qualifier: PassQualifier, WritingDescriptor: true}
+	p.term = &TermNode{Span: Span{-1, -1}}
+}
+
+// Extends the current term to the character at offset and returns its span
+func (p *treeParser) span(offset int, size int) Span {
+	if p.term.Span.Start < 0 {
+		p.term.Span.Start = offset
+	}
+
+	p.term.Span.End = offset + size
+	return Span{offset, offset + size}
+}
+
+// Appends a character to the name or value of the current term. The bytes are
+// copied as they are, so invalid UTF-8 keeps its length and the spans stay right
+func (p *treeParser) write(offset int, size int) {
+	span := p.span(offset, size)
+
+	if p.context.WritingDescriptor {
+		if p.context.Mechanism == "" {
+			p.term.NameSpan.Start = span.Start
+		}
+
+		p.context.Mechanism += p.tree.Text[span.Start:span.End]
+		p.term.NameSpan.End = span.End
+	} else {
+		if p.context.Value == "" {
+			p.term.ValueSpan.Start = span.Start
+		}
+
+		p.context.Value += p.tree.Text[span.Start:span.End]
+		p.term.ValueSpan.End = span.End
+	}
+}
+
+// Evaluates the current term, adds it to the tree and starts the next one
+func (p *treeParser) finish() error {
+	term := p.term
+	term.Text = p.tree.Text[term.Span.Start:term.Span.End]
+	var err error
+
+	if p.context.Modifier {
+		term.Mechanism, err = EvaluateModifier(&p.context)
+	} else {
+		term.Mechanism, err = EvaluateMechanism(&p.context)
+	}
+
+	switch err {
+	case nil:
+	case ErrInvalidMechanism:
+		return p.error(err, term.NameSpan.Start, term.Span.End, `one of "all", "include", "a", "mx", "ptr", "ip4", "ip6" or "exists"`)
+	case ErrInvalidModifier:
+		return p.error(err, term.NameSpan.Start, term.Span.End, `a modifier name of letters, digits, "-", "_" and "."`)
+	case ErrSyntax:
+		// Prefix lengths which are too large or at the wrong place
+		offset := strings.IndexByte(term.Text, '/') + term.Span.Start
+		return p.error(err, offset, term.Span.End, `"/" and a prefix length of at most 32 or "//" and one of at most 128 at the end`)
+	default:
+		return p.error(err, term.Span.Start, term.Span.End, "a valid term")
+	}
+
+	if term.Mechanism.Mechanism == AMechanism || term.Mechanism.Mechanism == MXMechanism {
+		// The prefix lengths are either part of the name like in "a/24" or
+		// follow the domain-spec like in "a:example.com/24"
+		if slash := strings.IndexByte(p.context.Mechanism, '/'); slash >= 0 {
+			term.CIDRSpan = Span{term.NameSpan.Start + slash, term.NameSpan.End}
+			term.NameSpan.End = term.CIDRSpan.Start
+		} else if !term.ValueSpan.Empty() {
+			term.CIDRSpan = Span{term.ValueSpan.Start + len(term.Mechanism.Value), term.ValueSpan.End}
+			term.ValueSpan.End = term.CIDRSpan.Start
+		}
+
+		if term.CIDRSpan.Empty() {
+			term.CIDRSpan = Span{}
+		}
+	}
+
+	if err := p.checkTerm(term); err != nil {
+		return err
+	}
+
+	p.tree.Terms = append(p.tree.Terms, term)
+	p.reset()
+	return nil
+}
+
+// Returns a ParseError for the text between start and end
+func (p *treeParser) error(err error, start int, end int, expected string) *ParseError {
+	return &ParseError{
+		Err:      err,
+		Offset:   start,
+		Column:   utf8.RuneCountInString(p.tree.Text[:start]) + 1,
+		Token:    p.tree.Text[start:end],
+		Expected: expected,
+	}
+}
+
+// Reports a problem which a strict parse rejects. It is returned as error
+// if the options are strict and added to the warnings of the tree otherwise
+func (p *treeParser) problem(err error, start int, end int, expected string) error {
+	problem := p.error(err, start, end, expected)
+
+	if p.options.Strict {
+		return problem
+	}
+
+	p.tree.Warnings = append(p.tree.Warnings, problem)
+	return nil
+}
+
+// Checks whitespace at offset. Terms are separated by spaces only and there
+// is no whitespace inside of terms
+func (p *treeParser) checkSpace(chr rune, offset int, size int) error {
+	switch {
+	case chr != ' ':
+		return p.problem(ErrSyntax, offset, offset+size, "a space between the terms")
+	case p.context.Mechanism == "" && !p.term.QualifierSpan.Empty():
+		return p.problem(ErrSyntax, offset, offset+size, "a mechanism directly after the qualifier")
+	case !p.context.WritingDescriptor && p.context.Value == "":
+		return p.problem(ErrSyntax, offset, offset+size, `a value directly after ":" or "="`)
+	}
+
+	return nil
+}
+
+// Checks the value of a term against the ABNF of RFC 7208 section 12
+func (p *treeParser) checkTerm(term *TermNode) error {
+	// A value which is missing is reported at the end of the term
+	value := term.ValueSpan
+
+	if value == (Span{}) {
+		value = Span{term.Span.End, term.Span.End}
+	}
+
+	separator := !p.context.WritingDescriptor
+
+	if term.IsModifier() && !term.QualifierSpan.Empty() {
+		if err := p.problem(ErrSyntax, term.QualifierSpan.Start, term.QualifierSpan.End, "a modifier without qualifier"); err != nil {
+			return err
+		}
+	}
+
+	switch term.Mechanism.Mechanism {
+	case AllMechanism:
+		if separator {
+			return p.problem(ErrSyntax, term.NameSpan.End, term.Span.End, "all without value")
+		}
+	case IPv4Mechanism, IPv6Mechanism:
+		if offset := invalidNetwork(p.tree.Text[value.Start:value.End], term.Mechanism.Mechanism == IPv6Mechanism); offset >= 0 {
+			return p.problem(ErrSyntax, value.Start+offset, value.End, `an address and optionally "/" and a prefix length`)
+		}
+	case AMechanism, MXMechanism:
+		if separator {
+			if err := p.checkDomainSpec(value); err != nil {
+				return err
+			}
+		}
+
+		cidr := p.tree.Text[term.CIDRSpan.Start:term.CIDRSpan.End]
+		ip4, ip6, dual := strings.Cut(cidr, "//")
+
+		if ip4 != "" && !isCIDRLength(ip4[1:], 32) || dual && !isCIDRLength(ip6, 128) {
+			return p.problem(ErrSyntax, term.CIDRSpan.Start, term.CIDRSpan.End, "prefix lengths without leading zeros")
+		}
+	case PTRMechanism:
+		if separator {
+			return p.checkDomainSpec(value)
+		}
+	case IncludeMechanism, ExistsMechanism, RedirectMechanism, ExpMechanism:
+		return p.checkDomainSpec(value)
+	case ModifierMechanism:
+		if offset := invalidMacroString(term.Value, macroLetters); offset >= 0 {
+			return p.problem(ErrInvalidMacro, value.Start+offset, value.End, `visible characters and macros like "%{d}", "%%", "%_" or "%-"`)
+		}
+	}
+
+	return nil
+}
+
+// Checks that the text of span is a domain-spec (RFC 7208 section 7.1)
+func (p *treeParser) checkDomainSpec(span Span) error {
+	spec := p.tree.Text[span.Start:span.End]
+
+	// The c, r and t macros are only allowed in explanations (RFC 7208 section 7.1)
+	if offset := invalidMacroString(spec, "slodiphv"); offset >= 0 {
+		return p.problem(ErrInvalidMacro, span.Start+offset, span.End, `visible characters and macros like "%{d}", "%%", "%_" or "%-" without c, r and t`)
+	}
+
+	if offset := invalidDomainEnd(spec); offset >= 0 {
+		return p.problem(ErrSyntax, span.Start+offset, span.End, `a domain-spec ending with a macro or a toplabel like ".com"`)
+	}
+
+	return nil
+}
+
+// Letters of the macros of a macro-string
+const macroLetters = "slodiphcrtv"
+
+// Returns the offset of the first byte of value which doesn't belong to a
+// macro-string with the given macro letters, or -1 if value is one
+func invalidMacroString(value string, letters string) int {
+	i := 0
+
+	for i < len(value) {
+		if end := macroExpandEnd(value, i, letters); end > i {
+			i = end
+		} else if value[i] > ' ' && value[i] < 0x7f && value[i] != '%' {
+			i++
+		} else {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Returns the end of the macro-expand at offset, or offset if there is none
+func macroExpandEnd(value string, offset int, letters string) int {
+	if offset+1 >= len(value) || value[offset] != '%' {
+		return offset
+	}
+
+	switch value[offset+1] {
+	case '%', '_', '-':
+		return offset + 2
+	case '{':
+	default:
+		return offset
+	}
+
+	i := offset + 2
+
+	if i == len(value) || strings.IndexByte(letters, value[i]|0x20) < 0 {
+		return offset
+	}
+
+	i++
+
+	for i < len(value) && value[i] >= '0' && value[i] <= '9' {
+		i++
+	}
+
+	if i < len(value) && value[i]|0x20 == 'r' {
+		i++
+	}
+
+	for i < len(value) && strings.IndexByte(macroDelimiters, value[i]) >= 0 {
+		i++
+	}
+
+	if i == len(value) || value[i] != '}' {
+		return offset
+	}
+
+	return i + 1
+}
+
+// Returns the offset where the domain-end of a valid macro-string fails, or
+// -1 if it ends with a macro-expand or with "." and a toplabel
+func invalidDomainEnd(spec string) int {
+	for i := 0; i < len(spec); {
+		end := macroExpandEnd(spec, i, macroLetters)
+
+		if end == len(spec) && end > i {
+			return -1
+		}
+
+		if end == i {
+			end++
+		}
+
+		i = end
+	}
+
+	name := strings.TrimSuffix(spec, ".")
+	dot := strings.LastIndexByte(name, '.')
+
+	if dot < 0 || !isTopLabel(name[dot+1:]) {
+		return dot + 1
+	}
+
+	return -1
+}
+
+// Checks if label is a toplabel: letters, digits and hyphens with at least
+// one letter or one hyphen between two letters or digits
+func isTopLabel(label string) bool {
+	alpha, hyphen := false, false
+
+	for i := 0; i < len(label); i++ {
+		c := label[i]
+
+		switch {
+		case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			alpha = true
+		case c >= '0' && c <= '9':
+		case c == '-' && i > 0 && i < len(label)-1:
+			hyphen = true
+		default:
+			return false
+		}
+	}
+
+	return alpha || hyphen
+}
+
+// Returns the offset of the invalid part of an ip4 or ip6 value with
+// optional prefix length, or -1 if it is valid
+func invalidNetwork(value string, ip6 bool) int {
+	address, length, hasLength := strings.Cut(value, "/")
+	valid, max := isIP4Network(address), 32
+
+	if ip6 {
+		valid, max = strings.Contains(address, ":") && net.ParseIP(address) != nil, 128
+	}
+
+	if !valid {
+		return 0
+	}
+
+	if hasLength && !isCIDRLength(length, max) {
+		return len(address)
+	}
+
+	return -1
+}
+
+// Checks if address is an ip4-network with four decimal numbers without leading zeros
+func isIP4Network(address string) bool {
+	parts := strings.Split(address, ".")
+
+	if len(parts) != 4 {
+		return false
+	}
+
+	for _, part := range parts {
+		if !isCIDRLength(part, 255) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks if length is a decimal number of at most max without leading zeros
+func isCIDRLength(length string, max int) bool {
+	if !isDigits(length) || len(length) > 1 && length[0] == '0' {
+		return false
+	}
+
+	n, err := strconv.Atoi(length)
+	return err == nil && n <= max
+}
@@ -0,0 +1,221 @@
+package spf_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/moverval/go-spf"
+	"github.com/moverval/go-spf/spftest"
+)
+
+func TestParseTree(t *testing.T) {
+	record := "v=spf1 -a:example.com/24//64 mx/28 include:  _spf.example.com redirect=example.org"
+	tree, err := spf.ParseTree(record)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	text := func(span spf.Span) string {
+		return record[span.Start:span.End]
+	}
+
+	expected := []struct {
+		term, qualifier, name, value, cidr string
+		mechanism                          spf.Mechanism
+	}{
+		{"-a:example.com/24//64", "-", "a", "example.com", "/24//64", spf.Mechanism{Qualifier: spf.FailQualifier, Mechanism: spf.AMechanism, Value: "example.com", IP4CIDR: spf.CIDRLength(24), IP6CIDR: spf.CIDRLength(64)}},
+		{"mx/28", "", "mx", "", "/28", spf.Mechanism{Mechanism: spf.MXMechanism, IP4CIDR: spf.CIDRLength(28)}},
+		{"include:  _spf.example.com", "", "include", "_spf.example.com", "", spf.Mechanism{Mechanism: spf.IncludeMechanism, Value: "_spf.example.com"}},
+		{"redirect=example.org", "", "redirect", "example.org", "", spf.Mechanism{Mechanism: spf.RedirectMechanism, Value: "example.org"}},
+	}
+
+	if text(tree.Version) != "v=spf1" || len(tree.Terms) != len(expected) {
+		t.Fatalf("Unexpected tree: %q with %d terms", text(tree.Version), len(tree.Terms))
+	}
+
+	for i, node := range tree.Terms {
+		e := expected[i]
+
+		if node.Text != e.term || text(node.Span) != e.term {
+			t.Errorf("Term %d: expected %q, got %q at %v", i, e.term, node.Text, node.Span)
+		}
+
+		parts := []string{text(node.QualifierSpan), text(node.NameSpan), text(node.ValueSpan), text(node.CIDRSpan)}
+
+		if !reflect.DeepEqual(parts, []string{e.qualifier, e.name, e.value, e.cidr}) {
+			t.Errorf("Term %d: unexpected parts %q", i, parts)
+		}
+
+		if !reflect.DeepEqual(node.Mechanism, e.mechanism) {
+			t.Errorf("Term %d: expected %v, got %v", i, e.mechanism, node.Mechanism)
+		}
+	}
+}
+
+func TestTermNodeJSON(t *testing.T) {
+	tree, err := spf.ParseTree("v=spf1 mx/28 -include:example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(tree.Terms)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `[{"mechanism":{"qualifier":"+","kind":"mx","ip4_cidr":28},"text":"mx/28",` +
+		`"span":{"start":7,"end":12},"name_span":{"start":7,"end":9},"cidr_span":{"start":9,"end":12}},` +
+		`{"mechanism":{"qualifier":"-","kind":"include","value":"example.com"},"text":"-include:example.com",` +
+		`"span":{"start":13,"end":33},"qualifier_span":{"start":13,"end":14},"name_span":{"start":14,"end":21},"value_span":{"start":22,"end":33}}]`
+
+	if string(data) != expected {
+		t.Errorf("Not as expected: '%s' does not equal to '%s'", data, expected)
+	}
+}
+
+func TestParseTreeRecord(t *testing.T) {
+	for _, record := range []string{
+		"v=spf1",
+		"v=spf1 exp=explain._spf.%{d} -include:ban.voulter.com a:voulter.com/24 ip6:::1 ~all",
+		"v=spf1 + ip4:127.0.0.1 x-custom=1 ?all",
+	} {
+		tree, err := spf.ParseTree(record)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := spf.ParseSPF(record)
+
+		if !reflect.DeepEqual(tree.Record(), expected) {
+			t.Errorf("Record of %q: expected %v, got %v", record, expected, tree.Record())
+		}
+	}
+}
+
+func TestParseTreeErrors(t *testing.T) {
+	tests := []struct {
+		record string
+		err    error
+		column int
+		token  string
+	}{
+		{"v=spf2 a", spf.ErrNoSPF, 1, "v=spf2"},
+		{"v=spf1 a include :example.com", spf.ErrSyntax, 18, ":"},
+		{"v=spf1 a mx:example.com/33 -all", spf.ErrSyntax, 24, "/33"},
+		{"v=spf1 ip4:192.0.2.1 ipv4:192.0.2.2", spf.ErrInvalidMechanism, 22, "ipv4:192.0.2.2"},
+		{"v=spf1 1x=y", spf.ErrInvalidModifier, 8, "1x=y"},
+		{"v=spf1 redirect=a.example redirect=b.example", spf.ErrDuplicateModifier, 27, "redirect=b.example"},
+		{"v=spf1 include:ä.example ä", spf.ErrInvalidMechanism, 26, "ä"},
+	}
+
+	for _, test := range tests {
+		_, err := spf.ParseTree(test.record)
+		var parseErr *spf.ParseError
+
+		if !errors.As(err, &parseErr) {
+			t.Errorf("%q: expected a ParseError, got %v", test.record, err)
+			continue
+		}
+
+		if !errors.Is(err, test.err) || parseErr.Column != test.column || parseErr.Token != test.token || parseErr.Expected == "" {
+			t.Errorf("%q: unexpected error %v", test.record, err)
+		}
+	}
+}
+
+func TestParseOptionsStrict(t *testing.T) {
+	tests := []struct {
+		record string
+		column int
+		token  string
+	}{
+		{"v=spf1 a/032 -all", 9, "/032"},
+		{"v=spf1 ip4:192.0.2.256 -all", 12, "192.0.2.256"},
+		{"v=spf1 ip4:192.0.2.0/33", 21, "/33"},
+		{"v=spf1 ip6", 11, ""},
+		{"v=spf1 include:example -all", 16, "example"},
+		{"v=spf1 a:example.123", 18, "123"},
+		{"v=spf1 exists:%{i}.example.com/24", 28, "com/24"},
+		{"v=spf1 redirect=", 17, ""},
+		{"v=spf1 exp=%{c}.example.com", 12, "%{c}.example.com"},
+		{"v=spf1 x-note=100%", 18, "%"},
+		{"v=spf1 all:example.com", 11, ":example.com"},
+		{"v=spf1 -redirect=example.com", 8, "-"},
+		{"v=spf1 a\tmx", 8, "a\tmx"},
+		{"v=spf1 a\r\nmx", 9, "\r"},
+		{"v=spf1 + a", 9, " "},
+		{"v=spf1 include: example.com", 16, " "},
+		{"v=spf1 a -", 10, "-"},
+	}
+
+	for _, test := range tests {
+		_, err := spf.ParseOptions{Strict: true}.ParseTree(test.record)
+		var parseErr *spf.ParseError
+
+		if !errors.As(err, &parseErr) || parseErr.Column != test.column || parseErr.Token != test.token {
+			t.Errorf("%q: unexpected error %v", test.record, err)
+		}
+
+		// Lenient parsing only fails for invalid mechanism names
+		tree, err := spf.ParseTree(test.record)
+
+		if errors.Is(err, spf.ErrInvalidMechanism) {
+			continue
+		}
+
+		if err != nil || len(tree.Warnings) == 0 || *tree.Warnings[0] != *parseErr {
+			t.Errorf("%q: expected warning %v, got %v (%v)", test.record, parseErr, tree, err)
+		}
+	}
+}
+
+func TestParseOptionsStrictValid(t *testing.T) {
+	for _, record := range []string{
+		"v=spf1",
+		"v=spf1  a/0 mx:mail.example.com/24//64 ptr:example.com. ip6:2001:db8::/32 ~all ",
+		"v=spf1 ip4:192.0.2.0/24 include:_spf.%{d} exists:%{ir}.%{v}._spf.%{d2} -all",
+		"v=spf1 redirect=_spf.example-1.com exp=explain.%{d} x-note=%{c}%%%_%-",
+		"v=spf1 a:example.1-2",
+	} {
+		_, warnings, err := spf.ParseOptions{Strict: true}.ParseSPF(record)
+
+		if err != nil || len(warnings) > 0 {
+			t.Errorf("%q: unexpected error %v", record, err)
+		}
+	}
+}
+
+func TestParseTreeInvalidUTF8(t *testing.T) {
+	for _, record := range []string{"v=spf1 a:\x8d0", "v=spf1 mx:ex\xffample.com/24", "v=spf1 a/24\xc3", "v=spf1 \x8d", "v=spf1 ip4:\xff redirect=\xfe.com"} {
+		for _, options := range []spf.ParseOptions{{}, {Strict: true}} {
+			tree, err := options.ParseTree(record)
+
+			if err != nil {
+				continue
+			}
+
+			for _, node := range tree.Terms {
+				for _, span := range []spf.Span{node.Span, node.QualifierSpan, node.NameSpan, node.ValueSpan, node.CIDRSpan} {
+					if span.Start < 0 || span.End > len(record) {
+						t.Errorf("%q: span %v is out of range", record, span)
+					}
+				}
+			}
+		}
+	}
+
+	checker := spf.NewChecker(&spftest.Zone{TXT: map[string][]string{"voulter.com": {"v=spf1 a:\x8d0"}}})
+	result, _, _ := checker.CheckHost(context.Background(), net.ParseIP("192.0.2.10"), "voulter.com", "info@voulter.com", "mail.voulter.com")
+
+	if result == spf.PassResult {
+		t.Errorf("Record with invalid UTF-8 shouldn't pass")
+	}
+}